@@ -1,12 +1,14 @@
 
 -- +goose Up
 -- SQL in section 'Up' is executed when this migration is applied
+CREATE SEQUENCE category_id_seq;
+
 create table categories(
        id int NOT NULL DEFAULT nextval('category_id_seq'),
 
        label varchar(255), 
        spending_limit bigint,
-       limit_interval int,          -- Maybe in days...
+       limit_interval int,          -- In days, 7, 30 and 365 mean calendar weeks, months and years
 
        account_group_id varchar(255),
 
@@ -21,3 +23,4 @@ CREATE INDEX ON categories (account_group_id);
 -- +goose Down
 -- SQL section 'Down' is executed when this migration is rolled back
 DROP TABLE categories;
+DROP SEQUENCE category_id_seq;
@@ -0,0 +1,10 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Existing rows were parsed as UTC, so interpret them that way.
+ALTER TABLE txns ALTER COLUMN occurred_at TYPE timestamptz USING occurred_at AT TIME ZONE 'UTC';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE txns ALTER COLUMN occurred_at TYPE timestamp USING occurred_at AT TIME ZONE 'UTC';
@@ -0,0 +1,26 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE SEQUENCE import_file_id_seq;
+
+create table import_files(
+       id bigint NOT NULL DEFAULT nextval('import_file_id_seq'),
+
+       file_hash varchar(64),         -- sha256 of the file contents
+       file_name varchar(255),        -- Name of the file when it was picked up
+       profile varchar(255),          -- Import profile used
+
+       status varchar(20),            -- imported || failed
+       imported int,                  -- Count of txns imported
+       errors int,                    -- Count of bad records
+
+       created bigint,                -- Server unix Timestamp when record is created.
+
+       PRIMARY KEY(id),
+       UNIQUE (file_hash)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE import_files;
+DROP SEQUENCE import_file_id_seq;
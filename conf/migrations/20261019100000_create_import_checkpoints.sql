@@ -0,0 +1,22 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+create table import_checkpoints(
+       file_hash varchar(64) NOT NULL, -- sha256 of the file contents
+       file_name varchar(255),
+
+       records bigint,                 -- Records read (good and bad) as of the last committed batch
+       byte_offset bigint,             -- Offset in the file of the next record to read
+
+       imported int,                   -- Count of txns imported so far
+       errors int,                     -- Count of bad records so far
+       completed boolean,              -- True once the whole file has been read
+
+       updated bigint,                 -- Server unix Timestamp of the last checkpoint
+
+       PRIMARY KEY(file_hash)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE import_checkpoints;
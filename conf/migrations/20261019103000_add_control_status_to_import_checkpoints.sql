@@ -0,0 +1,10 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE import_checkpoints ADD COLUMN control_status varchar(20) NOT NULL DEFAULT '';  -- matched || mismatched
+ALTER TABLE import_checkpoints ADD COLUMN control_message text NOT NULL DEFAULT '';        -- Description of any discrepancy
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE import_checkpoints DROP COLUMN control_message;
+ALTER TABLE import_checkpoints DROP COLUMN control_status;
@@ -0,0 +1,40 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE SEQUENCE recurring_series_id_seq;
+
+create table recurring_series(
+       id bigint NOT NULL DEFAULT nextval('recurring_series_id_seq'),
+
+       account_group_id varchar(255), -- Account groups represent members
+       txn_group_id int,              -- FK to the TxnGroup the txns belong to
+       txn_type varchar(2),           -- W || D
+
+       label varchar(255),            -- Copied from the TxnGroup for display
+       txn_group_type varchar(255),   -- Retail, Bill Payment, Loan, Cheque, etc
+       classification varchar(255),   -- Transportation, Groceries, Department, Dining, etc
+
+       cadence varchar(20),           -- weekly || biweekly || monthly || annual
+       amount bigint,                 -- Typical (median) amount, pennies
+       occurrences int,               -- Number of txns seen in the series
+
+       first_seen timestamptz,
+       last_seen timestamptz,
+       last_amount bigint,
+
+       next_expected timestamptz,     -- When the next txn is due
+       next_amount bigint,            -- What it's expected to be, pennies
+
+       created bigint,                -- Server unix Timestamp when record is created.
+
+       PRIMARY KEY(id),
+       UNIQUE (account_group_id, txn_group_id, txn_type)
+);
+
+CREATE INDEX ON recurring_series (account_group_id);
+CREATE INDEX ON recurring_series (next_expected);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE recurring_series;
+DROP SEQUENCE recurring_series_id_seq;
@@ -0,0 +1,33 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE SEQUENCE anomaly_id_seq;
+
+create table anomalies(
+       id bigint NOT NULL DEFAULT nextval('anomaly_id_seq'),
+
+       account_group_id varchar(255), -- Account groups represent members
+       kind varchar(20),              -- txn || txn_group || classification
+       period_start timestamptz,      -- Start of the month the anomaly is in
+
+       txn_id bigint,                 -- FK to the Txn for txn anomalies, otherwise 0
+       txn_group_id int,              -- FK to the TxnGroup, 0 for classification anomalies
+       classification varchar(255),   -- Transportation, Groceries, Department, Dining, etc
+       label varchar(255),            -- Txn group label, classification or txn description for display
+       occurred_at timestamptz,       -- When the txn occurred, the month start otherwise
+
+       amount bigint,                 -- What was spent, pennies
+       usual bigint,                  -- What is usually spent (the median), pennies
+       ratio double precision,        -- Amount over usual
+       score double precision,        -- How many deviations from usual
+
+       created bigint,                -- Server unix Timestamp when record is created.
+
+       PRIMARY KEY(id)
+);
+
+CREATE INDEX ON anomalies (account_group_id, period_start);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE anomalies;
+DROP SEQUENCE anomaly_id_seq;
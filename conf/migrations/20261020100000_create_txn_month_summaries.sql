@@ -0,0 +1,37 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Txn counts and totals per account group, month and txn group, kept up to
+-- date by imports so reports on whole months needn't scan txns.
+create table txn_month_summaries(
+       account_group_id varchar(255) NOT NULL, -- Account groups represent members
+       month date NOT NULL,                    -- First day of the month, in timezone
+       timezone varchar(255) NOT NULL,         -- IANA name months are reckoned in
+
+       txn_type varchar(2) NOT NULL,           -- W || D
+       system_txn_group_id int NOT NULL,       -- FK to system TxnGroup
+       txn_group_id int NOT NULL,              -- FK to a TxnGroup
+       txn_group_type varchar(255) NOT NULL,   -- Retail, Bill Payment, Loan, Cheque, etc
+       classification varchar(255) NOT NULL,   -- Transportation, Groceries, Department, Dining, etc
+
+       txn_count int NOT NULL,                 -- How many txns
+       amount bigint NOT NULL,                 -- Their total, pennies
+
+       PRIMARY KEY(account_group_id, month, timezone, txn_type, system_txn_group_id, txn_group_id, txn_group_type, classification)
+);
+
+CREATE INDEX ON txn_month_summaries (timezone, month);
+
+-- The timezones summaries have been built in by summaries:rebuild, and so
+-- are maintained by imports and read by reports.
+create table txn_summary_builds(
+       timezone varchar(255) NOT NULL,
+       rebuilt timestamptz NOT NULL,
+
+       PRIMARY KEY(timezone)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE txn_summary_builds;
+DROP TABLE txn_month_summaries;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE import_checkpoints ADD COLUMN imported_debits bigint NOT NULL DEFAULT 0;   -- Sum of the W amounts imported, pennies
+ALTER TABLE import_checkpoints ADD COLUMN imported_credits bigint NOT NULL DEFAULT 0;  -- Sum of the D amounts imported, pennies
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE import_checkpoints DROP COLUMN imported_credits;
+ALTER TABLE import_checkpoints DROP COLUMN imported_debits;
@@ -0,0 +1,353 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// The kinds of anomaly
+const (
+	AnomalyTxn            = "txn"            // A single txn much larger than usual at its txn group
+	AnomalyTxnGroup       = "txn_group"      // A month's spending at a txn group
+	AnomalyClassification = "classification" // A month's spending in a classification
+)
+
+// Scores are capped, a history that never varies makes any change infinitely unusual
+const maxAnomalyScore = 99
+
+// Fewest months (or txns, for single txn anomalies) of history worth comparing against
+const minAnomalyHistory = 3
+const minAnomalyTxnHistory = 5
+
+// What counts as unusual
+type AnomalyOptions struct {
+	Location      *time.Location
+	Month         time.Time // Start of the month to look for anomalies in
+	HistoryMonths int       // Months before Month to compare against
+
+	Threshold float64 // Score at or above which spending is unusual
+	MinRatio  float64 // Fewest times usual spending must be to be unusual
+	MinAmount int64   // Least (pennies) spending must be over usual to be unusual
+}
+
+// Return how many deviations value is above the history: a robust z-score
+// using the median absolute deviation, falling back to the ordinary z-score
+// when most of the history is the same.
+func anomaly_score(history []float64, value float64) float64 {
+
+	if len(history) == 0 {
+		return 0
+	}
+
+	med := median(history)
+
+	deviations := make([]float64, len(history))
+	for i, h := range history {
+		deviations[i] = math.Abs(h - med)
+	}
+
+	var score float64
+
+	if mad := median(deviations); mad > 0 {
+		score = 0.6745 * (value - med) / mad
+	} else {
+		mean, sd := meanStdDev(history)
+		if sd > 0 {
+			score = (value - mean) / sd
+		} else if value > med {
+			score = maxAnomalyScore
+		}
+	}
+
+	return math.Min(score, maxAnomalyScore)
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// Return an anomaly if value is unusual for the history, nil otherwise
+func (opts *AnomalyOptions) check(history []float64, value int64) *Anomaly {
+
+	usual := median(history)
+	if usual <= 0 {
+		return nil // Nothing usual to compare against
+	}
+
+	amount := float64(value)
+	if amount < usual*opts.MinRatio || amount-usual < float64(opts.MinAmount) {
+		return nil
+	}
+
+	score := anomaly_score(history, amount)
+	if score < opts.Threshold {
+		return nil
+	}
+
+	return &Anomaly{
+		PeriodStart: opts.Month,
+		OccurredAt:  opts.Month,
+		Amount:      value,
+		Usual:       int64(usual),
+		Ratio:       amount / usual,
+		Score:       score,
+	}
+}
+
+// A month's withdrawals at a txn group or in a classification
+type anomalyMonthTotal struct {
+	Id             int64
+	Label          string
+	Classification string
+	Month          string
+	Sum            int64
+}
+
+// A single withdrawal in the month being checked
+type anomalyTxn struct {
+	Id             int64
+	TxnGroupId     int64 `db:"txn_group_id"`
+	Description    string
+	Classification string
+	Amount         int64
+	OccurredAt     time.Time `db:"occurred_at"`
+}
+
+// Find an account group's unusual spending for the month in opts, compared
+// with its own history.
+func detect_anomalies(accountGroupId string, opts *AnomalyOptions, dbm gorp.SqlExecutor) ([]*Anomaly, error) {
+
+	monthEnd := opts.Month.AddDate(0, 1, 0)
+
+	// Only months the member has been around for make up the history
+	var first []struct{ First time.Time }
+	_, err := dbm.Select(&first, "SELECT min(occurred_at) as first FROM txns WHERE account_group_id = :id HAVING count(*) > 0",
+		map[string]interface{}{"id": accountGroupId})
+	if err != nil || len(first) == 0 {
+		return nil, err
+	}
+
+	joined := first[0].First.In(opts.Location)
+	joined = time.Date(joined.Year(), joined.Month(), 1, 0, 0, 0, 0, opts.Location)
+
+	var months []string
+	for i := 1; i <= opts.HistoryMonths; i++ {
+		month := opts.Month.AddDate(0, -i, 0)
+		if month.Before(joined) {
+			break
+		}
+		months = append(months, month.Format("2006-01"))
+	}
+
+	if len(months) < minAnomalyHistory {
+		return nil, nil
+	}
+
+	params := map[string]interface{}{
+		"id":     accountGroupId,
+		"tz":     opts.Location.String(),
+		"hstart": opts.Month.AddDate(0, -len(months), 0),
+		"mstart": opts.Month,
+		"mend":   monthEnd,
+	}
+
+	var anomalies []*Anomaly
+
+	var groupTotals []anomalyMonthTotal
+	_, err = dbm.Select(&groupTotals, "SELECT t.txn_group_id as id, coalesce(g.label, '') as label, coalesce(g.classification, '') as classification, "+
+		"to_char(date_trunc('month', t.occurred_at AT TIME ZONE :tz), 'YYYY-MM') as month, sum(t.amount) as sum "+
+		"FROM txns t JOIN txn_groups g ON g.id = t.txn_group_id "+
+		"WHERE t.account_group_id = :id AND t.txn_type = 'W' AND t.occurred_at >= :hstart AND t.occurred_at < :mend "+
+		"GROUP BY 1, 2, 3, 4", params)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range monthAnomalies(groupTotals, months, opts, func(t anomalyMonthTotal) string { return strconv.FormatInt(t.Id, 10) }) {
+		a.Kind = AnomalyTxnGroup
+		anomalies = append(anomalies, a)
+	}
+
+	var classTotals []anomalyMonthTotal
+	_, err = dbm.Select(&classTotals, "SELECT 0 as id, classification as label, classification, "+
+		"to_char(date_trunc('month', occurred_at AT TIME ZONE :tz), 'YYYY-MM') as month, sum(amount) as sum "+
+		"FROM txns WHERE account_group_id = :id AND txn_type = 'W' AND classification <> '' "+
+		"AND occurred_at >= :hstart AND occurred_at < :mend GROUP BY 2, 3, 4", params)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range monthAnomalies(classTotals, months, opts, func(t anomalyMonthTotal) string { return t.Classification }) {
+		a.Kind = AnomalyClassification
+		anomalies = append(anomalies, a)
+	}
+
+	txnAnomalies, err := detect_txn_anomalies(params, opts, dbm)
+	if err != nil {
+		return nil, err
+	}
+
+	anomalies = append(anomalies, txnAnomalies...)
+
+	for _, a := range anomalies {
+		a.AccountGroupId = accountGroupId
+	}
+
+	sort.Sort(byScore(anomalies))
+
+	return anomalies, nil
+}
+
+// Compare each txn group's (or classification's) total for the month with
+// its totals for the history months, no spending in a month counts as 0.
+func monthAnomalies(totals []anomalyMonthTotal, months []string, opts *AnomalyOptions, key func(t anomalyMonthTotal) string) []*Anomaly {
+
+	current := opts.Month.Format("2006-01")
+
+	byKey := map[string]map[string]int64{}
+	labels := map[string]anomalyMonthTotal{}
+
+	for _, t := range totals {
+		k := key(t)
+		if byKey[k] == nil {
+			byKey[k] = map[string]int64{}
+		}
+		byKey[k][t.Month] += t.Sum
+		labels[k] = t
+	}
+
+	var anomalies []*Anomaly
+
+	for k, sums := range byKey {
+		value, ok := sums[current]
+		if !ok {
+			continue
+		}
+
+		history := make([]float64, len(months))
+		for i, month := range months {
+			history[i] = float64(sums[month])
+		}
+
+		if a := opts.check(history, value); a != nil {
+			a.TxnGroupId = labels[k].Id
+			a.Label = labels[k].Label
+			a.Classification = labels[k].Classification
+			anomalies = append(anomalies, a)
+		}
+	}
+
+	return anomalies
+}
+
+// Compare each withdrawal in the month with the other withdrawals at its txn
+// group over the history.
+func detect_txn_anomalies(params map[string]interface{}, opts *AnomalyOptions, dbm gorp.SqlExecutor) ([]*Anomaly, error) {
+
+	var history []anomalyMonthTotal
+	_, err := dbm.Select(&history, "SELECT txn_group_id as id, amount as sum FROM txns "+
+		"WHERE account_group_id = :id AND txn_type = 'W' AND txn_group_id <> 0 "+
+		"AND occurred_at >= :hstart AND occurred_at < :mstart", params)
+	if err != nil {
+		return nil, err
+	}
+
+	amounts := map[int64][]float64{}
+	for _, h := range history {
+		amounts[h.Id] = append(amounts[h.Id], float64(h.Sum))
+	}
+
+	var txns []anomalyTxn
+	_, err = dbm.Select(&txns, "SELECT id, txn_group_id, coalesce(description, '') as description, coalesce(classification, '') as classification, "+
+		"amount, occurred_at FROM txns WHERE account_group_id = :id AND txn_type = 'W' AND txn_group_id <> 0 "+
+		"AND occurred_at >= :mstart AND occurred_at < :mend", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var anomalies []*Anomaly
+
+	for _, txn := range txns {
+		if len(amounts[txn.TxnGroupId]) < minAnomalyTxnHistory {
+			continue
+		}
+
+		if a := opts.check(amounts[txn.TxnGroupId], txn.Amount); a != nil {
+			a.Kind = AnomalyTxn
+			a.TxnId = txn.Id
+			a.TxnGroupId = txn.TxnGroupId
+			a.Classification = txn.Classification
+			a.Label = strings.TrimSpace(txn.Description)
+			a.OccurredAt = txn.OccurredAt
+			anomalies = append(anomalies, a)
+		}
+	}
+
+	return anomalies, nil
+}
+
+type byScore []*Anomaly
+
+func (a byScore) Len() int           { return len(a) }
+func (a byScore) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byScore) Less(i, j int) bool { return a[i].Score > a[j].Score }
+
+// Replace an account group's anomalies for a month
+func store_anomalies(accountGroupId string, month time.Time, anomalies []*Anomaly, dbm *gorp.DbMap) error {
+
+	tx, err := dbm.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM anomalies WHERE account_group_id = $1 AND period_start = $2", accountGroupId, month); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, a := range anomalies {
+		a.Created = time.Now().UnixNano()
+		if err := tx.Insert(a); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,194 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The name of the manifest in a bundle
+const bundleManifest = "manifest.csv"
+
+// What a bundle's manifest says about the run as a whole
+type BundleInfo struct {
+	PeriodStart     string // yyyy-mm-dd
+	PeriodEnd       string
+	Template        string // The template file, or json or csv for report data
+	TemplateVersion string
+}
+
+// The manifest columns, one row per report
+var bundleManifestHeader = []string{"account_group_id", "file", "sha256", "bytes", "period_start", "period_end",
+	"template", "template_version", "status", "error"}
+
+// Return the archive format for a bundle file name, zip or tar.gz
+func bundleFormat(filename string) (string, error) {
+
+	switch name := strings.ToLower(filename); {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip", nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz", nil
+	}
+
+	return "", fmt.Errorf("bundle '%s' must be a .zip, .tar.gz or .tgz file", filename)
+}
+
+// Write the manifest of a run's reports.  Failed reports are listed with
+// their error and no file.
+func writeBundleManifest(w io.Writer, info *BundleInfo, results []*RenderResult) error {
+
+	out := csv.NewWriter(w)
+	out.Write(bundleManifestHeader)
+
+	for _, result := range results {
+		file, status, message := filepath.Base(result.File), "ok", ""
+		if result.Err != nil {
+			file, status, message = "", "failed", result.Err.Error()
+		}
+
+		out.Write([]string{result.AccountGroupId, file, result.Checksum, strconv.FormatInt(result.Bytes, 10),
+			info.PeriodStart, info.PeriodEnd, info.Template, info.TemplateVersion, status, message})
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// Package the rendered reports, along with a manifest of every report
+// (rendered or not), as a zip or tar.gz file.  Nothing is left behind if
+// the bundle can't be written.
+func writeBundle(filename string, info *BundleInfo, results []*RenderResult) error {
+
+	format, err := bundleFormat(filename)
+	if err != nil {
+		return err
+	}
+
+	// Reports are stored by file name, so two with the same name would
+	// collide when the bundle is unpacked
+	names := map[string]bool{bundleManifest: true}
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+
+		name := filepath.Base(result.File)
+		if names[name] {
+			return fmt.Errorf("bundle '%s' would contain '%s' twice", filename, name)
+		}
+		names[name] = true
+	}
+
+	var manifest bytes.Buffer
+	if err := writeBundleManifest(&manifest, info, results); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	var add func(name string, size int64, r io.Reader) error
+	var finish func() error
+
+	if format == "zip" {
+		zw := zip.NewWriter(f)
+
+		add = func(name string, size int64, r io.Reader) error {
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, r)
+			return err
+		}
+		finish = zw.Close
+	} else {
+		gw := gzip.NewWriter(f)
+		tw := tar.NewWriter(gw)
+
+		add = func(name string, size int64, r io.Reader) error {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now()}); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, r)
+			return err
+		}
+		finish = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gw.Close()
+		}
+	}
+
+	addFile := func(path string) error {
+		report, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer report.Close()
+
+		stat, err := report.Stat()
+		if err != nil {
+			return err
+		}
+
+		return add(filepath.Base(path), stat.Size(), report)
+	}
+
+	err = add(bundleManifest, int64(manifest.Len()), &manifest)
+
+	for _, result := range results {
+		if err != nil {
+			break
+		}
+		if result.Err == nil {
+			err = addFile(result.File)
+		}
+	}
+
+	if err == nil {
+		err = finish()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(filename)
+	}
+
+	return err
+}
@@ -0,0 +1,522 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"strings"
+)
+
+// Charts are laid out as a list of simple shapes (rects, polygons, lines and
+// text) and then drawn, so the same chart can be drawn as SVG or anything
+// else that can draw those shapes.  Pie slices are polygons rather than arcs
+// for that reason.
+type Chart struct {
+	Width  float64
+	Height float64
+	Font   string
+	Shapes []ChartShape
+}
+
+type ChartPoint struct {
+	X float64
+	Y float64
+}
+
+type ChartShape struct {
+	Kind string // rect || polygon || polyline || text
+
+	X, Y, W, H float64 // Rect position and size, text position
+	Points     []ChartPoint
+
+	Fill        string
+	Stroke      string
+	StrokeWidth float64
+
+	Text     string
+	FontSize float64
+	Anchor   string // start || middle || end
+	Bold     bool
+}
+
+func (c *Chart) rect(x, y, w, h float64, fill string) {
+	c.Shapes = append(c.Shapes, ChartShape{Kind: "rect", X: x, Y: y, W: w, H: h, Fill: fill})
+}
+
+func (c *Chart) line(x1, y1, x2, y2 float64, stroke string, width float64) {
+	c.Shapes = append(c.Shapes, ChartShape{Kind: "polyline", Points: []ChartPoint{{x1, y1}, {x2, y2}},
+		Stroke: stroke, StrokeWidth: width})
+}
+
+func (c *Chart) text(x, y float64, text string, size float64, anchor string, fill string) {
+	c.Shapes = append(c.Shapes, ChartShape{Kind: "text", X: x, Y: y, Text: text, FontSize: size, Anchor: anchor, Fill: fill})
+}
+
+// Draw the chart as an inline SVG element
+func (c *Chart) SVG() string {
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %s %s" font-family="%s">`,
+		num(c.Width), num(c.Height), num(c.Width), num(c.Height), html.EscapeString(c.Font))
+
+	for _, s := range c.Shapes {
+		switch s.Kind {
+		case "rect":
+			fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`,
+				num(s.X), num(s.Y), num(s.W), num(s.H), s.Fill)
+		case "polygon":
+			fmt.Fprintf(&b, `<polygon points="%s" fill="%s" stroke="%s" stroke-width="%s"/>`,
+				svgPoints(s.Points), s.Fill, svgPaint(s.Stroke), num(s.StrokeWidth))
+		case "polyline":
+			fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%s"/>`,
+				svgPoints(s.Points), s.Stroke, num(s.StrokeWidth))
+		case "text":
+			weight := ""
+			if s.Bold {
+				weight = ` font-weight="bold"`
+			}
+			fmt.Fprintf(&b, `<text x="%s" y="%s" font-size="%s" text-anchor="%s" fill="%s"%s>%s</text>`,
+				num(s.X), num(s.Y), num(s.FontSize), s.Anchor, s.Fill, weight, html.EscapeString(s.Text))
+		}
+	}
+
+	b.WriteString("</svg>")
+
+	return b.String()
+}
+
+// Format a coordinate without needless decimals
+func num(f float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", f), "0"), ".")
+}
+
+func svgPoints(points []ChartPoint) string {
+	pieces := make([]string, len(points))
+	for i, p := range points {
+		pieces[i] = num(p.X) + "," + num(p.Y)
+	}
+	return strings.Join(pieces, " ")
+}
+
+func svgPaint(color string) string {
+	if color == "" {
+		return "none"
+	}
+	return color
+}
+
+// One labelled value to chart
+type ChartDatum struct {
+	Label string
+	Value float64
+}
+
+// How a chart looks, set from the "key=value" options given to the chart
+// template functions
+type ChartOptions struct {
+	Width    float64
+	Height   float64
+	Title    string
+	Colors   []string
+	Font     string
+	FontSize float64
+	Labels   bool   // Label each slice, bar or point
+	Values   bool   // Show each value
+	Legend   bool   // Pie charts only
+	Currency bool   // Values are pennies, as most are
+	Value    string // Which value of the data to chart, see toChartData
+	Stroke   float64
+	Locale   *Locale // For formatting values, the report's locale
+}
+
+var defaultChartColors = []string{"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f",
+	"#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac"}
+
+const chartTextColor = "#333333"
+const chartGridColor = "#dddddd"
+
+// Parse "key=value" options over the defaults
+func parseChartOptions(kind string, locale *Locale, options []string) (*ChartOptions, error) {
+
+	opts := &ChartOptions{
+		Width:    400,
+		Height:   250,
+		Colors:   defaultChartColors,
+		Font:     "Helvetica, Arial, sans-serif",
+		FontSize: 11,
+		Labels:   true,
+		Values:   kind == "bar",
+		Legend:   kind == "pie",
+		Currency: true,
+		Stroke:   2,
+		Locale:   locale,
+	}
+
+	for _, option := range options {
+		pieces := strings.SplitN(option, "=", 2)
+		if len(pieces) != 2 {
+			return nil, fmt.Errorf("bad chart option %q, expected key=value", option)
+		}
+
+		key, value := strings.TrimSpace(pieces[0]), strings.TrimSpace(pieces[1])
+
+		var err error
+
+		switch key {
+		case "width":
+			err = positive(value, &opts.Width)
+		case "height":
+			err = positive(value, &opts.Height)
+		case "fontsize":
+			err = positive(value, &opts.FontSize)
+		case "stroke":
+			err = positive(value, &opts.Stroke)
+		case "title":
+			opts.Title = value
+		case "font":
+			opts.Font = value
+		case "value":
+			opts.Value = value
+		case "color", "colors":
+			opts.Colors = nil
+			for _, color := range strings.Split(value, ",") {
+				color = strings.TrimSpace(color)
+				if !validColor(color) {
+					return nil, fmt.Errorf("bad chart color %q", color)
+				}
+				opts.Colors = append(opts.Colors, color)
+			}
+		case "labels":
+			opts.Labels = value == "true"
+		case "values":
+			opts.Values = value == "true"
+		case "legend":
+			opts.Legend = value == "true"
+		case "currency":
+			opts.Currency = value == "true"
+		default:
+			return nil, fmt.Errorf("unknown chart option %q", key)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("bad chart option %q: %v", option, err)
+		}
+	}
+
+	return opts, nil
+}
+
+func positive(value string, into *float64) error {
+	var f float64
+	if _, err := fmt.Sscanf(value, "%g", &f); err != nil {
+		return err
+	}
+	if f <= 0 {
+		return fmt.Errorf("must be positive")
+	}
+	*into = f
+	return nil
+}
+
+// Colors end up in attributes, so only allow names, #hex and rgb(...)
+func validColor(color string) bool {
+	if color == "" {
+		return false
+	}
+	for _, c := range color {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("#(),. %", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (opts *ChartOptions) color(i int) string {
+	return opts.Colors[i%len(opts.Colors)]
+}
+
+// Format a value for a label, dollars for pennies
+func (opts *ChartOptions) format(value float64) string {
+	if opts.Currency {
+		return opts.Locale.Currency(int64(math.Round(value)))
+	}
+	return opts.Locale.Number(value, 0)
+}
+
+// Format an axis value compactly, $1.2k rather than $1,200.00
+func (opts *ChartOptions) formatAxis(value float64) string {
+
+	prefix, suffix := "", ""
+	if opts.Currency {
+		prefix, suffix = opts.Locale.CurrencyPrefix, opts.Locale.CurrencySuffix
+		value /= 100
+	}
+
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	compact := func(value float64, unit string) string {
+		s := strings.TrimSuffix(opts.Locale.Number(value, 1), opts.Locale.Decimal+"0")
+		return sign + prefix + s + unit + suffix
+	}
+
+	switch {
+	case value >= 1e6:
+		return compact(value/1e6, "M")
+	case value >= 1e3:
+		return compact(value/1e3, "k")
+	}
+
+	return compact(value, "")
+}
+
+// Shorten a label to fit about width pixels
+func (opts *ChartOptions) fit(label string, width float64) string {
+	max := int(width / (opts.FontSize * 0.55))
+	runes := []rune(label)
+	if max < 2 || len(runes) <= max {
+		return label
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// Lay out the title, returning the y the rest of the chart starts at
+func (c *Chart) title(opts *ChartOptions) float64 {
+	if opts.Title == "" {
+		return 4
+	}
+	size := opts.FontSize * 1.3
+	c.Shapes = append(c.Shapes, ChartShape{Kind: "text", X: c.Width / 2, Y: size + 2, Text: opts.Title,
+		FontSize: size, Anchor: "middle", Fill: chartTextColor, Bold: true})
+	return size*2 + 2
+}
+
+// Lay out a pie chart, each slice's share of the total of the positive values
+func pieChart(data []ChartDatum, opts *ChartOptions) *Chart {
+
+	c := &Chart{Width: opts.Width, Height: opts.Height, Font: opts.Font}
+	top := c.title(opts)
+
+	total := 0.0
+	for _, d := range data {
+		if d.Value > 0 {
+			total += d.Value
+		}
+	}
+
+	pieWidth := opts.Width
+	if opts.Legend {
+		pieWidth = opts.Width * 0.5
+	}
+
+	radius := math.Min(pieWidth, opts.Height-top)/2 - 6
+	if radius < 1 || total == 0 {
+		return c
+	}
+
+	cx, cy := pieWidth/2, top+(opts.Height-top)/2
+
+	angle := -math.Pi / 2 // Start at 12 o'clock
+	legendY := top + opts.FontSize
+
+	for i, d := range data {
+		if d.Value <= 0 {
+			continue
+		}
+
+		share := d.Value / total
+		sweep := share * 2 * math.Pi
+
+		points := []ChartPoint{{cx, cy}}
+		steps := int(math.Ceil(sweep / (math.Pi / 90))) // Every 2 degrees
+		for s := 0; s <= steps; s++ {
+			a := angle + sweep*float64(s)/float64(steps)
+			points = append(points, ChartPoint{cx + radius*math.Cos(a), cy + radius*math.Sin(a)})
+		}
+
+		c.Shapes = append(c.Shapes, ChartShape{Kind: "polygon", Points: points, Fill: opts.color(i),
+			Stroke: "#ffffff", StrokeWidth: 1})
+
+		if opts.Values && share >= 0.05 {
+			mid := angle + sweep/2
+			c.text(cx+radius*0.65*math.Cos(mid), cy+radius*0.65*math.Sin(mid)+opts.FontSize/3,
+				fmt.Sprintf("%.0f%%", share*100), opts.FontSize, "middle", "#ffffff")
+		}
+
+		if opts.Legend && legendY+opts.FontSize <= opts.Height {
+			x := pieWidth + 4
+			c.rect(x, legendY-opts.FontSize*0.8, opts.FontSize*0.8, opts.FontSize*0.8, opts.color(i))
+
+			label := d.Label
+			if opts.Labels {
+				label += " " + opts.format(d.Value)
+			}
+			c.text(x+opts.FontSize*1.2, legendY, opts.fit(label, opts.Width-x-opts.FontSize*1.2), opts.FontSize, "start", chartTextColor)
+
+			legendY += opts.FontSize * 1.5
+		}
+
+		angle += sweep
+	}
+
+	return c
+}
+
+// The plot area of a bar or line chart, with its value axis laid out
+type chartPlot struct {
+	left, top, right, bottom float64
+	min, max                 float64
+}
+
+func (p *chartPlot) y(value float64) float64 {
+	return p.bottom - (value-p.min)/(p.max-p.min)*(p.bottom-p.top)
+}
+
+// Lay out the value axis and grid lines for data, leaving room for labels
+func (c *Chart) plot(data []ChartDatum, opts *ChartOptions, top float64) *chartPlot {
+
+	min, max := 0.0, 0.0
+	for _, d := range data {
+		min = math.Min(min, d.Value)
+		max = math.Max(max, d.Value)
+	}
+	if min == max {
+		max = min + 1
+	}
+
+	step := niceStep((max - min) / 4)
+	min = math.Floor(min/step) * step
+	max = math.Ceil(max/step) * step
+
+	p := &chartPlot{left: opts.FontSize * 4.5, top: top, right: opts.Width - 6, bottom: opts.Height - 4, min: min, max: max}
+	if opts.Labels {
+		p.bottom -= opts.FontSize * 1.6
+	}
+	if opts.Values {
+		p.top += opts.FontSize * 1.2
+	}
+
+	for v := min; v <= max+step/2; v += step {
+		y := p.y(v)
+		c.line(p.left, y, p.right, y, chartGridColor, 1)
+		c.text(p.left-4, y+opts.FontSize/3, opts.formatAxis(v), opts.FontSize*0.9, "end", chartTextColor)
+	}
+
+	return p
+}
+
+// Round a step up to 1, 2 or 5 times a power of ten
+func niceStep(rough float64) float64 {
+	if rough <= 0 {
+		return 1
+	}
+	power := math.Pow(10, math.Floor(math.Log10(rough)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*power >= rough {
+			return m * power
+		}
+	}
+	return 10 * power
+}
+
+// Lay out a bar chart, one bar per value, negative values hang below zero
+func barChart(data []ChartDatum, opts *ChartOptions) *Chart {
+
+	c := &Chart{Width: opts.Width, Height: opts.Height, Font: opts.Font}
+	if len(data) == 0 {
+		c.title(opts)
+		return c
+	}
+
+	p := c.plot(data, opts, c.title(opts))
+
+	slot := (p.right - p.left) / float64(len(data))
+	zero := p.y(0)
+
+	for i, d := range data {
+		x := p.left + slot*float64(i) + slot*0.15
+		y := p.y(d.Value)
+
+		c.rect(x, math.Min(y, zero), slot*0.7, math.Abs(zero-y), opts.color(i))
+
+		if opts.Values {
+			vy := math.Min(y, zero) - 3
+			c.text(x+slot*0.35, vy, opts.fit(opts.format(d.Value), slot), opts.FontSize*0.9, "middle", chartTextColor)
+		}
+
+		if opts.Labels {
+			c.text(x+slot*0.35, p.bottom+opts.FontSize*1.3, opts.fit(d.Label, slot), opts.FontSize, "middle", chartTextColor)
+		}
+	}
+
+	return c
+}
+
+// Lay out a line chart through the values in order, labelling as many
+// points as fit
+func lineChart(data []ChartDatum, opts *ChartOptions) *Chart {
+
+	c := &Chart{Width: opts.Width, Height: opts.Height, Font: opts.Font}
+	if len(data) == 0 {
+		c.title(opts)
+		return c
+	}
+
+	p := c.plot(data, opts, c.title(opts))
+
+	slot := (p.right - p.left) / float64(len(data))
+
+	// Label every nth point so labels don't overlap
+	every := int(math.Ceil(opts.FontSize * 5 / slot))
+	if every < 1 {
+		every = 1
+	}
+
+	var points []ChartPoint
+
+	for i, d := range data {
+		x := p.left + slot*(float64(i)+0.5)
+		y := p.y(d.Value)
+		points = append(points, ChartPoint{x, y})
+
+		if i%every != 0 {
+			continue
+		}
+
+		if opts.Labels {
+			c.text(x, p.bottom+opts.FontSize*1.3, opts.fit(d.Label, slot*float64(every)), opts.FontSize, "middle", chartTextColor)
+		}
+
+		if opts.Values {
+			c.text(x, y-5, opts.formatAxis(d.Value), opts.FontSize*0.9, "middle", chartTextColor)
+		}
+	}
+
+	c.Shapes = append(c.Shapes, ChartShape{Kind: "polyline", Points: points, Stroke: opts.color(0), StrokeWidth: opts.Stroke})
+
+	return c
+}
@@ -0,0 +1,131 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+var anomalyUsage = "anomalies:detect [-id=accountgroupid] [-month=yyyy-mm] [-history=months] [-threshold=score] [-ratio=times] [-min=pennies]"
+
+var anomalyCmd = &Command{
+	Name:    "anomalies:detect",
+	Usage:   anomalyUsage,
+	Summary: "Flag unusual spending for a month",
+	Help: `
+Compares each member's spending in a month (default last month) with their
+own history: each txn group's and classification's total for the month with
+the previous months, and each withdrawal with the others at its txn group.
+Spending is flagged when its score (deviations from usual, using the median
+absolute deviation) reaches -threshold, it is at least -ratio times usual
+and at least -min pennies over usual.
+
+Anomalies found for the month replace any found for it before.`,
+	Run: anomalyRun,
+}
+
+var anomalyAccountGroupId string
+var anomalyMonth string
+var anomalyHistory int
+var anomalyThreshold float64
+var anomalyRatio float64
+var anomalyMin int64
+
+func init() {
+	anomalyCmd.Flag.StringVar(&anomalyAccountGroupId, "id", "", "Only look at a single account group.")
+	anomalyCmd.Flag.StringVar(&anomalyMonth, "month", "", "The month to look for anomalies in (default last month).")
+	anomalyCmd.Flag.IntVar(&anomalyHistory, "history", 12, "Months of history to compare against.")
+	anomalyCmd.Flag.Float64Var(&anomalyThreshold, "threshold", 3.5, "Score at or above which spending is unusual.")
+	anomalyCmd.Flag.Float64Var(&anomalyRatio, "ratio", 1.5, "Fewest times usual spending must be to be unusual.")
+	anomalyCmd.Flag.Int64Var(&anomalyMin, "min", 2000, "Least spending over usual (pennies) to be unusual.")
+}
+
+func anomalyRun(cmd *Command, args ...string) {
+
+	if anomalyHistory < minAnomalyHistory || anomalyThreshold <= 0 || anomalyRatio < 1 || anomalyMin < 0 {
+		printError("-history must be at least 3, -threshold positive, -ratio at least 1 and -min not negative\n", anomalyUsage)
+		return
+	}
+
+	loc, err := loadLocation("")
+	if err != nil {
+		printError(err.Error()+"\n", anomalyUsage)
+		return
+	}
+
+	now := time.Now().In(loc)
+	month := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, loc)
+
+	if anomalyMonth != "" {
+		month, err = time.ParseInLocation("2006-01", anomalyMonth, loc)
+		if err != nil {
+			printError("Bad -month, expected yyyy-mm\n", anomalyUsage)
+			return
+		}
+	}
+
+	opts := &AnomalyOptions{
+		Location:      loc,
+		Month:         month,
+		HistoryMonths: anomalyHistory,
+		Threshold:     anomalyThreshold,
+		MinRatio:      anomalyRatio,
+		MinAmount:     anomalyMin,
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	defer timeTrack(time.Now(), "Anomaly detection")
+
+	var ids []string
+	if anomalyAccountGroupId != "" {
+		ids = append(ids, anomalyAccountGroupId)
+	} else {
+		_, err := dbm.Select(&ids, "SELECT DISTINCT account_group_id FROM txns WHERE occurred_at >= :start AND occurred_at < :end",
+			map[string]interface{}{"start": month, "end": month.AddDate(0, 1, 0)})
+		if err != nil {
+			log.Printf("Error finding account groups: %v", err)
+			return
+		}
+	}
+
+	total := 0
+
+	for _, id := range ids {
+		anomalies, err := detect_anomalies(id, opts, dbm)
+		if err == nil {
+			err = store_anomalies(id, month, anomalies, dbm)
+		}
+
+		if err != nil {
+			log.Printf("Error detecting anomalies for %s: %v", id, err)
+			continue
+		}
+
+		if len(anomalies) > 0 {
+			log.Printf("Found %d anomalies for %s", len(anomalies), id)
+		}
+		total += len(anomalies)
+	}
+
+	log.Printf("Found %d anomalies for %d account groups in %s", total, len(ids), month.Format("Jan 2006"))
+}
@@ -0,0 +1,257 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+var categoryAddUsage = "category:add -id=accountgroupid -label=label [-limit=pennies] [-interval=days]"
+
+var categoryAddCmd = &Command{
+	Name:    "category:add",
+	Usage:   categoryAddUsage,
+	Summary: "Add a spending category (with optional budget) for a member",
+	Help: `
+The limit is the most the member wants to spend in the category each
+interval, in pennies.  The interval is in days, 7, 30 and 365 follow calendar
+weeks, months and years.`,
+	Run: categoryAddRun,
+}
+
+var categoryListCmd = &Command{
+	Name:    "category:list",
+	Usage:   "category:list -id=accountgroupid",
+	Summary: "List a member's spending categories",
+	Help: `
+Lists each category's id, label, spending limit (pennies) and limit interval.`,
+	Run: categoryListRun,
+}
+
+var categoryRemoveCmd = &Command{
+	Name:    "category:remove",
+	Usage:   "category:remove categoryid",
+	Summary: "Remove a spending category",
+	Help: `
+Txn groups and txns assigned to the category are left uncategorized.`,
+	Run: categoryRemoveRun,
+}
+
+var categoryAssignCmd = &Command{
+	Name:    "category:assign",
+	Usage:   "category:assign -category=categoryid txngroupid ...",
+	Summary: "Assign txn groups (and their txns) to a spending category",
+	Help: `
+New txns imported for the txn groups are assigned to the category as well.
+A category of 0 unassigns the txn groups.`,
+	Run: categoryAssignRun,
+}
+
+var categoryAccountGroupId string
+var categoryLabel string
+var categoryLimit int64
+var categoryInterval int
+var categoryListId string
+var categoryAssignId int64
+
+func init() {
+	categoryAddCmd.Flag.StringVar(&categoryAccountGroupId, "id", "", "The account group the category belongs to.")
+	categoryAddCmd.Flag.StringVar(&categoryLabel, "label", "", "Name of the category.")
+	categoryAddCmd.Flag.Int64Var(&categoryLimit, "limit", 0, "Spending limit per interval in pennies (0 for none).")
+	categoryAddCmd.Flag.IntVar(&categoryInterval, "interval", LimitMonthly, "Length of the limit's interval in days.")
+
+	categoryListCmd.Flag.StringVar(&categoryListId, "id", "", "The account group to list categories for.")
+
+	categoryAssignCmd.Flag.Int64Var(&categoryAssignId, "category", -1, "The category to assign the txn groups to.")
+}
+
+func categoryAddRun(cmd *Command, args ...string) {
+
+	if categoryAccountGroupId == "" || categoryLabel == "" {
+		printError("Missing option: -id and -label are required\n", categoryAddUsage)
+		return
+	}
+
+	if categoryLimit < 0 || categoryInterval < 1 {
+		printError("-limit can't be negative and -interval must be at least 1\n", categoryAddUsage)
+		return
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	category := &Category{
+		Label:          categoryLabel,
+		SpendingLimit:  categoryLimit,
+		LimitInterval:  categoryInterval,
+		AccountGroupId: categoryAccountGroupId,
+		Created:        time.Now().Unix(),
+	}
+
+	if err := dbm.Insert(category); err != nil {
+		log.Printf("Error adding category: %v", err)
+		return
+	}
+
+	fmt.Printf("Added category %d\n", category.Id)
+}
+
+func categoryListRun(cmd *Command, args ...string) {
+
+	if categoryListId == "" {
+		printError("Missing option: -id is required\n", cmd.Usage)
+		return
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	var categories []Category
+	_, err := dbm.Select(&categories, "SELECT * FROM categories WHERE account_group_id = :id ORDER BY label",
+		map[string]interface{}{"id": categoryListId})
+
+	if err != nil {
+		log.Printf("Error listing categories: %v", err)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Id\tLabel\tLimit\tInterval (days)")
+	for _, c := range categories {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\n", c.Id, c.Label, c.SpendingLimit, c.LimitInterval)
+	}
+	w.Flush()
+}
+
+func categoryRemoveRun(cmd *Command, args ...string) {
+
+	if len(args) != 1 {
+		printError("Missing category id\n", cmd.Usage)
+		return
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		printError("Bad category id\n", cmd.Usage)
+		return
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	tx, err := dbm.Begin()
+	if err != nil {
+		log.Printf("Error removing category: %v", err)
+		return
+	}
+
+	for _, query := range []string{
+		"UPDATE txns SET category_id = 0 WHERE category_id = $1",
+		"UPDATE txn_groups SET category_id = 0 WHERE category_id = $1",
+		"DELETE FROM categories WHERE id = $1",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			tx.Rollback()
+			log.Printf("Error removing category: %v", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error removing category: %v", err)
+	}
+}
+
+func categoryAssignRun(cmd *Command, args ...string) {
+
+	if categoryAssignId < 0 || len(args) == 0 {
+		printError("Missing option: -category and at least one txn group id are required\n", cmd.Usage)
+		return
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	var category *Category
+	if categoryAssignId != 0 {
+		obj, err := dbm.Get(Category{}, categoryAssignId)
+		if err != nil || obj == nil {
+			log.Printf("Error: no category %d (%v)", categoryAssignId, err)
+			return
+		}
+		category = obj.(*Category)
+	}
+
+	for _, arg := range args {
+		groupId, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			log.Printf("Error: bad txn group id %q", arg)
+			continue
+		}
+
+		obj, err := dbm.Get(TxnGroup{}, groupId)
+		if err != nil || obj == nil {
+			log.Printf("Error: no txn group %d (%v)", groupId, err)
+			continue
+		}
+		group := obj.(*TxnGroup)
+
+		if category != nil && group.AccountGroupId != category.AccountGroupId {
+			log.Printf("Error: txn group %d belongs to a different account group than category %d", groupId, category.Id)
+			continue
+		}
+
+		if err := assign_txn_group_category(group, categoryAssignId, dbm); err != nil {
+			log.Printf("Error assigning txn group %d: %v", groupId, err)
+			continue
+		}
+
+		log.Printf("Assigned txn group %d '%s' to category %d", groupId, group.Label, categoryAssignId)
+	}
+}
+
+// Set the category of a txn group and all of its txns
+func assign_txn_group_category(group *TxnGroup, categoryId int64, dbm *gorp.DbMap) error {
+
+	tx, err := dbm.Begin()
+	if err != nil {
+		return err
+	}
+
+	group.CategoryId = categoryId
+	if _, err := tx.Update(group); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE txns SET category_id = $1 WHERE txn_group_id = $2", categoryId, group.Id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
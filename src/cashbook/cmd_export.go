@@ -0,0 +1,136 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+var exportUsage = "txns:export -id=accountgroupid (-start=yyyy-mm-dd -end=yyyy-mm-dd | -period=name [-asof=yyyy-mm-dd] [-fiscalstart=month]) [-output=file.xlsx]"
+
+var exportCmd = &Command{
+	Name:    "txns:export",
+	Usage:   exportUsage,
+	Summary: "Export a member's txns to Excel",
+	Help: `
+Writes an Excel workbook of a member's txns for the period: a summary of
+withdrawals and deposits, a Transactions sheet listing each txn with its
+txn group, group type, classification and category, and sheets totalling
+the txns by txn group type and by classification.
+
+The period is given by -start and -end or by -period (with -fiscalstart),
+as for report.  The workbook is written to -output, <id>.xlsx by default, or
+to standard output if -output is -.`,
+	Run: exportRun,
+}
+
+var exportAccountGroupId string
+var exportStart string
+var exportEnd string
+var exportPeriod string
+var exportAsOf string
+var exportFiscalStart int
+var exportOutput string
+
+func init() {
+	exportCmd.Flag.StringVar(&exportAccountGroupId, "id", "", "The account group to export.")
+	exportCmd.Flag.StringVar(&exportStart, "start", "", "Sets the start date to export.")
+	exportCmd.Flag.StringVar(&exportEnd, "end", "", "Sets the end date to export.")
+	exportCmd.Flag.StringVar(&exportPeriod, "period", "", "Export a named period instead of -start and -end (last-month, ytd...).")
+	exportCmd.Flag.StringVar(&exportAsOf, "asof", "", "Work -period out as of this date rather than today.")
+	exportCmd.Flag.IntVar(&exportFiscalStart, "fiscalstart", 1, "Month (1 to 12) fiscal years start in.")
+	exportCmd.Flag.StringVar(&exportOutput, "output", "", "File to write, <id>.xlsx by default.")
+}
+
+func exportRun(cmd *Command, args ...string) {
+
+	if exportAccountGroupId == "" {
+		printError("Missing option: -id is required\n", exportUsage)
+		return
+	}
+
+	if exportPeriod == "" && (exportStart == "" || exportEnd == "") {
+		printError("Missing option: -start and -end or -period are required\n", exportUsage)
+		return
+	}
+
+	if exportPeriod != "" && (exportStart != "" || exportEnd != "") {
+		printError("-period can't be given with -start or -end\n", exportUsage)
+		return
+	}
+
+	loc, err := loadLocation("")
+	if err != nil {
+		printError(err.Error()+"\n", exportUsage)
+		return
+	}
+
+	start, end, err := periodDates(exportPeriod, exportAsOf, exportStart, exportEnd, time.Month(exportFiscalStart), loc)
+	if err != nil {
+		printError(err.Error()+"\n", exportUsage)
+		return
+	}
+
+	output := exportOutput
+	if output == "" {
+		output = exportAccountGroupId + ".xlsx"
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	api := &ReportingApi{PeriodStart: start, PeriodEnd: end, Location: loc, AccountGroupId: exportAccountGroupId, dbmap: dbm}
+
+	book, err := api.workbook()
+	if err != nil {
+		log.Printf("Error exporting txns: %v", err)
+		return
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Printf("Error exporting txns: %v", err)
+			return
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	if err := book.Write(w); err != nil {
+		log.Printf("Error exporting txns: %v", err)
+		return
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Printf("Error exporting txns: %v", err)
+		return
+	}
+
+	if output != "-" {
+		log.Printf("Exported %s to %s", exportAccountGroupId, output)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var forecastUsage = "forecast [-id=accountgroupid | -system] [-days=30|60|90] [-asof=yyyy-mm-dd] [-csv]"
+
+var forecastCmd = &Command{
+	Name:    "forecast",
+	Usage:   forecastUsage,
+	Summary: "Forecast a member's daily inflows and outflows",
+	Help: `
+Prints the projected inflow, outflow, net and cumulative net for each of the
+days after -asof (default today), followed by totals for the next 30, 60 and
+90 days (as far as -days goes).
+
+Recurring bills and deposits come from recurring:detect, so run it first.
+Everything else is projected from the last 90 days of history.  Amounts are
+in dollars, -csv prints the days as CSV instead.`,
+	Run: forecastRun,
+}
+
+var forecastAccountGroupId string
+var forecastSystem bool
+var forecastDays int
+var forecastAsOf string
+var forecastCsv bool
+
+func init() {
+	forecastCmd.Flag.StringVar(&forecastAccountGroupId, "id", "", "The account group to forecast.")
+	forecastCmd.Flag.BoolVar(&forecastSystem, "system", false, "Forecast the aggregate of all account groups.")
+	forecastCmd.Flag.IntVar(&forecastDays, "days", 30, "Number of days to forecast.")
+	forecastCmd.Flag.StringVar(&forecastAsOf, "asof", "", "Forecast the days after this date (default today).")
+	forecastCmd.Flag.BoolVar(&forecastCsv, "csv", false, "Print the days as CSV.")
+}
+
+func forecastRun(cmd *Command, args ...string) {
+
+	if forecastAccountGroupId == "" && !forecastSystem {
+		printError("Missing option: One of id or system is required\n", forecastUsage)
+		return
+	}
+
+	if forecastDays < 1 {
+		printError("-days must be at least 1\n", forecastUsage)
+		return
+	}
+
+	loc, err := loadLocation("")
+	if err != nil {
+		printError(err.Error()+"\n", forecastUsage)
+		return
+	}
+
+	asOf := time.Now().In(loc)
+	if forecastAsOf != "" {
+		asOf, err = time.ParseInLocation(shortForm, forecastAsOf+" 23:59:59", loc)
+		if err != nil {
+			printError("Bad -asof date, expected yyyy-mm-dd\n", forecastUsage)
+			return
+		}
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	id := forecastAccountGroupId
+	if forecastSystem {
+		id = ""
+	}
+
+	// The forecast starts the day after the (empty) report period ends
+	api := &ReportingApi{PeriodStart: asOf, PeriodEnd: asOf, Location: loc, AccountGroupId: id, dbmap: dbm}
+
+	forecast := api.Forecast(forecastDays)
+	if forecast == nil {
+		log.Printf("Error: forecast failed")
+		return
+	}
+
+	if forecastCsv {
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"date", "inflow", "outflow", "net", "cumulative"})
+		for _, day := range forecast.Days {
+			w.Write([]string{day.Date.Format("2006-01-02"), dollars(day.Inflow), dollars(day.Outflow),
+				dollars(day.Net), dollars(day.Cumulative)})
+		}
+		w.Flush()
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Date\tInflow\tOutflow\tNet\tCumulative\t")
+	for _, day := range forecast.Days {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", day.Date.Format("2006-01-02"), dollars(day.Inflow),
+			dollars(day.Outflow), dollars(day.Net), dollars(day.Cumulative))
+	}
+	w.Flush()
+
+	fmt.Println()
+
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Next\tInflow\tOutflow\tNet\tLowest\t")
+	for _, days := range []int{30, 60, 90} {
+		if days > len(forecast.Days) {
+			break
+		}
+
+		inflow, outflow, lowest := 0, 0, 0
+		for i, day := range forecast.Days[:days] {
+			inflow += day.Inflow
+			outflow += day.Outflow
+			if i == 0 || day.Cumulative < lowest {
+				lowest = day.Cumulative
+			}
+		}
+
+		fmt.Fprintf(w, "%d days\t%s\t%s\t%s\t%s\t\n", days, dollars(inflow), dollars(outflow), dollars(inflow-outflow), dollars(lowest))
+	}
+	w.Flush()
+}
+
+// Format pennies as a plain dollar amount, like -12.34
+func dollars(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
@@ -20,7 +20,6 @@
 package main
 
 import (
-	"os"
 	"io"
 	"encoding/csv"
 	"log"
@@ -28,16 +27,46 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"database/sql"
+	"github.com/coopernurse/gorp"
 )
 
 var importCsvCmd = &Command{
 	Name:    "importcsv",
-	Usage:   "",
-	Summary: "Import a csv file",
-	Help:    `import csv extended help here...`,
+	Usage:   "importcsv [-timezone=zone] [-batch=n] [-resume | -restart] [-control=trailer|sidecar [-mismatch=reject|flag]] file|glob|dir|- ...",
+	Summary: "Import csv files",
+	Help:    `
+Imports each of the named files.  Globs are expanded, a directory imports
+every file in it (in name order) and - reads from standard input.  gzip,
+bzip2 and zip files are decompressed as they are read.
+
+With -control, each file's record count and debit/credit totals are checked
+against those stated by the core, either in a final trailer record:
+
+    TRAILER <tab> records <tab> debits <tab> credits
+
+or in a <file>.ctl sidecar of records=, debits= and credits= lines (amounts
+in pennies).  A file that doesn't match is rejected before anything is
+imported, or with -mismatch=flag imported and flagged.`,
 	Run:     importCsvRun,
 }
 
+var importTimezone string
+var importResume bool
+var importRestart bool
+var importBatchSize int
+var importControl string
+var importMismatch string
+
+func init() {
+	importCsvCmd.Flag.StringVar(&importTimezone, "timezone", "", "Timezone the file's dates are in (defaults to the FI -timezone).")
+	importCsvCmd.Flag.BoolVar(&importResume, "resume", false, "Continue an interrupted import from its last checkpoint.")
+	importCsvCmd.Flag.BoolVar(&importRestart, "restart", false, "Import a file again from the start, discarding its checkpoint.")
+	importCsvCmd.Flag.IntVar(&importBatchSize, "batch", defaultBatchSize, "Number of records committed (and checkpointed) at a time.")
+	importCsvCmd.Flag.StringVar(&importControl, "control", "", "Verify control totals from a 'trailer' record or 'sidecar' .ctl file.")
+	importCsvCmd.Flag.StringVar(&importMismatch, "mismatch", "reject", "On a control total mismatch, 'reject' the file or 'flag' it and import anyway.")
+}
+
 const (
 	TXNTYPE  = iota
 	AMOUNT
@@ -49,7 +78,10 @@ const (
 	ACCOUNTGROUPID
 )
 
+const defaultBatchSize = 1000
+
 // Date format:  RFC3339     = "2006-01-02T15:04:05Z07:00"
+// Dates in the file carry no zone, they are read as local to -timezone.
 
 func importCsvRun(cmd *Command, args ...string) {
 
@@ -60,80 +92,408 @@ func importCsvRun(cmd *Command, args ...string) {
 		return
 	}
 
+	loc, err := loadLocation(importTimezone)
+	if err != nil {
+		log.Println("Error:", err)
+		return
+	}
+
+	if importBatchSize < 1 {
+		log.Printf("Error: -batch must be at least 1, not %d", importBatchSize)
+		return
+	}
+
+	if importResume && importRestart {
+		log.Print("Error: -resume and -restart can't be used together")
+		return
+	}
+
+	if importMismatch != "reject" && importMismatch != "flag" {
+		log.Printf("Error: -mismatch must be reject or flag, not %q", importMismatch)
+		return
+	}
+
+	paths, err := expand_import_paths(args)
+	if err != nil {
+		log.Println("Error:", err)
+		return
+	}
+
 	dbm := initDb()
 	defer dbm.Db.Close()
 
 	log.Printf("DB Connected.")
 
-	matchers := match_set_from_file(*flagMatchers)
-
-	file, err := os.Open(args[0])
-    if err != nil {
-        log.Println("Error:", err)
-        return
-    }
-    defer file.Close()
-    reader := csv.NewReader(file)
-	reader.Comma = '\t'// Use tab-separated values
+	opts := ImportOptions{
+		Location: loc,
+		Matchers: match_set_from_file(*flagMatchers),
+		BatchSize: importBatchSize,
+		Resume: importResume,
+		Restart: importRestart,
+		Control: importControl,
+		RejectMismatch: importMismatch == "reject",
+	}
 
 	defer timeTrack(time.Now(), "CSV Import")
 
-	count := 0
-	err_count := 0
-
-    for {
-        record, err := reader.Read()
-        if err == io.EOF {
-            break
-        } else if err != nil {
-            log.Println("Error:", err)
-            return
-        }
- 		
-		amount, err := strconv.ParseInt(strings.TrimSpace(record[AMOUNT]), 10, 64) // Pennies!
+	total := &ImportResult{}
+	files := 0
+	flagged := 0
 
+	for _, path := range paths {
+		inputs, cleanup, err := open_import_inputs(path)
 		if err != nil {
-			log.Printf("error parsing amount: %v: record =  %v", err, record)
-			err_count++
+			log.Printf("Error opening '%s': %v", path, err)
+			cleanup()
 			continue
 		}
 
-		occurred_at, err := time.Parse("02 Jan 06 15:04:05", strings.TrimSpace(record[OCCURREDAT]))
+		for _, input := range inputs {
+			result, err := import_input(input, opts, dbm)
+			if err != nil {
+				log.Printf("Error importing '%s': %v", input.Name, err)
+			}
 
-		if err != nil {
-			log.Printf("error parsing occured_at: %v: record =  %v", err, record)
-			err_count++
+			if result.Resumed > 0 {
+				log.Printf("%s: resumed after record %d", input.Name, result.Resumed)
+			}
+
+			log.Printf("%s: imported %d records, %d bad records", input.Name, result.Imported, result.Errors)
+
+			total.Imported += result.Imported
+			total.Errors += result.Errors
+			files++
+
+			if result.ControlStatus == "mismatched" {
+				flagged++
+			}
+		}
+
+		cleanup()
+	}
+
+	log.Printf("Imported %d records, %d bad records from %d files", total.Imported, total.Errors, files)
+
+	if flagged > 0 {
+		log.Printf("%d files did not match their control totals", flagged)
+	}
+}
+
+// Settings for a single run of the importer
+type ImportOptions struct {
+	Location *time.Location // Zone the file's dates are local to
+	Matchers MatchSet
+
+	FileHash string // Checkpoints are kept against the input's hash
+	FileName string
+	BatchSize int   // Records per committed batch
+	Resume bool     // Skip what was committed by a previous run
+	Restart bool    // Import from the start even if a previous run got further
+
+	Control string       // Where to find control totals: "", trailer or sidecar
+	RejectMismatch bool  // Refuse to import when control totals don't match
+	ControlTotals *ControlTotals  // What the input says it holds, nil if unknown
+
+	// Outcome of the control total check, recorded with the checkpoint
+	ControlStatus string
+	ControlMessage string
+}
+
+// Counts for a single run of the importer
+type ImportResult struct {
+	Imported int
+	Errors   int
+	Resumed  int64 // Records skipped as already committed
+
+	Debits  int64 // Totals of the txns imported
+	Credits int64
+
+	ControlStatus string // matched || mismatched, empty if not checked
+}
+
+// Read tab-separated txns from r, assigning each to its txn group as it goes.
+// Txns are committed in batches along with a checkpoint of how far through
+// the file the import is.  Bad records are counted and skipped, a read error
+// stops the import leaving the last committed checkpoint to resume from.
+func importCsv(r io.Reader, opts *ImportOptions, dbm *gorp.DbMap) (*ImportResult, error) {
+
+	result := &ImportResult{ControlStatus: opts.ControlStatus}
+
+	checkpoint, found, err := load_import_checkpoint(opts.FileHash, dbm)
+	if err != nil {
+		return result, err
+	}
+
+	if found && !opts.Resume {
+		if !opts.Restart {
+			return result, fmt.Errorf("'%s' was imported before (%d records read), use -resume to continue from there or -restart to import it all again", opts.FileName, checkpoint.Records)
+		}
+
+		log.Printf("Restarting '%s', which was imported before (%d records read)", opts.FileName, checkpoint.Records)
+		checkpoint = &ImportCheckpoint{FileHash: opts.FileHash}
+	}
+
+	if opts.Resume && checkpoint.Completed {
+		log.Printf("'%s' has already been completely imported", opts.FileName)
+		return result, nil
+	}
+
+	// Keep the monthly summaries up to date as txns are added
+	summaries, err := load_summary_batch(dbm)
+	if err != nil {
+		return result, err
+	}
+
+	checkpoint.FileName = opts.FileName
+	checkpoint.ControlStatus = opts.ControlStatus
+	checkpoint.ControlMessage = opts.ControlMessage
+
+	// Byte offsets are relative to where reading starts
+	baseOffset := int64(0)
+	skip := int64(0)
+
+	if opts.Resume && checkpoint.Records > 0 {
+		// Jump straight to the next record when we can, otherwise read and
+		// discard the records already committed.
+		if seeker, ok := r.(io.Seeker); ok {
+			if _, err := seeker.Seek(checkpoint.ByteOffset, io.SeekStart); err != nil {
+				return result, err
+			}
+			baseOffset = checkpoint.ByteOffset
+		} else {
+			skip = checkpoint.Records
+		}
+
+		result.Resumed = checkpoint.Records
+	} else {
+		checkpoint.Records = 0
+		checkpoint.ByteOffset = 0
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comma = '\t' // Use tab-separated values
+	reader.FieldsPerRecord = -1
+
+	batch := &ImportResult{}
+
+	tx, err := dbm.Begin()
+	if err != nil {
+		return result, err
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		checkpoint.Records++
+		checkpoint.ByteOffset = baseOffset + reader.InputOffset()
+
+		if is_trailer(record) {
 			continue
 		}
 
-		txn := &Txn{
-			TxnType: strings.TrimSpace(record[TXNTYPE]),
-			Amount: amount, // Remember, in pennies.
-			Description: strings.TrimSpace(record[DESC]),
-			OccurredAt: occurred_at,
-			TxnHostType: strings.TrimSpace(record[TXNHOSTTYPE]),
-			TraceNumber:  strings.TrimSpace(record[TRACENUMBER]),
-			CombinationKey: strings.TrimSpace(record[COMBINATIONKEY]),
-			AccountGroupId: strings.TrimSpace(record[ACCOUNTGROUPID]),
+		if import_record(record, opts, summaries, batch, tx) {
+			batch.Imported++
+		} else {
+			batch.Errors++
 		}
 
-		_ = assign_txn_to_txn_group(txn, matchers, dbm)
-		count++
+		if batch.Imported + batch.Errors == opts.BatchSize {
+			if err := commit_import_batch(tx, checkpoint, batch, summaries, result, found); err != nil {
+				return result, err
+			}
+
+			found = true
+			batch = &ImportResult{}
 
-		if count % 500 == 0 {
-			fmt.Print(".")  // Show progress every 1000 records
+			fmt.Print(".")  // Show progress every batch
+
+			if tx, err = dbm.Begin(); err != nil {
+				return result, err
+			}
 		}
-    }
+	}
+
+	checkpoint.Completed = true
+
+	if err := commit_import_batch(tx, checkpoint, batch, summaries, result, found); err != nil {
+		return result, err
+	}
+
+	if err := check_imported_totals(checkpoint, opts, dbm); err != nil {
+		return result, err
+	}
+	result.ControlStatus = checkpoint.ControlStatus
+
+	return result, nil
+}
+
+// Compare what was imported, over every run of the import, with the control
+// totals.  Records that didn't make it into the database (bad records, failed
+// inserts) show up as a discrepancy, flagging the checkpoint as mismatched.
+func check_imported_totals(checkpoint *ImportCheckpoint, opts *ImportOptions, dbm *gorp.DbMap) error {
+
+	if opts.ControlTotals == nil || checkpoint.ControlStatus == "mismatched" {
+		return nil
+	}
+
+	imported := &ControlTotals{
+		Records: int64(checkpoint.Imported),
+		Debits:  checkpoint.ImportedDebits,
+		Credits: checkpoint.ImportedCredits,
+	}
+
+	diffs := opts.ControlTotals.Discrepancies(imported)
+	if len(diffs) == 0 {
+		return nil
+	}
 
-	log.Printf("Imported %d records, %d bad records", count, err_count)
+	checkpoint.ControlStatus = "mismatched"
+	checkpoint.ControlMessage = "imported " + strings.Join(diffs, "; ")
+
+	log.Printf("%s: imported txns do not match the control totals: %s", opts.FileName, checkpoint.ControlMessage)
+
+	_, err := dbm.Update(checkpoint)
+	return err
 }
 
+// Parse a single record and save it as a txn, adding it to the batch's
+// totals.  Returns false for a bad record.
+func import_record(record []string, opts *ImportOptions, summaries *summaryBatch, batch *ImportResult, tx *gorp.Transaction) bool {
 
+	txn, err := parse_record(record, opts.Location)
+	if err != nil {
+		log.Printf("error %v: record =  %v", err, record)
+		return false
+	}
 
+	// A failed insert (duplicate trace number, etc) aborts the whole
+	// transaction in postgres, so isolate each txn in a savepoint.
+	if err := tx.Savepoint("txn"); err != nil {
+		log.Printf("error creating savepoint: %v", err)
+		return false
+	}
 
+	if _, err := assign_txn_to_txn_group(txn, opts.Matchers, tx); err != nil {
+		tx.RollbackToSavepoint("txn")
+		return false
+	}
 
+	tx.ReleaseSavepoint("txn")
 
+	// Invalid txns aren't saved
+	if txn.Id == 0 {
+		return false
+	}
 
+	summaries.add(txn)
 
+	switch txn.TxnType {
+	case "W":
+		batch.Debits += txn.Amount
+	case "D":
+		batch.Credits += txn.Amount
+	}
 
+	return true
+}
 
+// Record the checkpoint and the batch's summaries and commit the batch, adding
+// its counts to the result
+func commit_import_batch(tx *gorp.Transaction, checkpoint *ImportCheckpoint, batch *ImportResult, summaries *summaryBatch, result *ImportResult, exists bool) error {
+
+	if err := summaries.flush(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	checkpoint.Imported += batch.Imported
+	checkpoint.Errors += batch.Errors
+	checkpoint.ImportedDebits += batch.Debits
+	checkpoint.ImportedCredits += batch.Credits
+	checkpoint.Updated = time.Now().UnixNano()
+
+	var err error
+	if exists {
+		_, err = tx.Update(checkpoint)
+	} else {
+		err = tx.Insert(checkpoint)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	result.Imported += batch.Imported
+	result.Errors += batch.Errors
+	result.Debits += batch.Debits
+	result.Credits += batch.Credits
+
+	return nil
+}
+
+// Return the checkpoint for the file with the given hash and whether one was
+// recorded before.
+func load_import_checkpoint(hash string, dbm *gorp.DbMap) (*ImportCheckpoint, bool, error) {
+
+	checkpoint := &ImportCheckpoint{FileHash: hash}
+
+	err := dbm.SelectOne(checkpoint, "SELECT * FROM import_checkpoints WHERE file_hash = :hash",
+		map[string]interface{}{"hash": hash})
+
+	if err == sql.ErrNoRows {
+		return checkpoint, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return checkpoint, true, nil
+}
+
+// Turn a record into an (unsaved) txn
+func parse_record(record []string, loc *time.Location) (*Txn, error) {
+
+	if len(record) <= ACCOUNTGROUPID {
+		return nil, fmt.Errorf("too few fields")
+	}
+
+	amount, err := strconv.ParseInt(strings.TrimSpace(record[AMOUNT]), 10, 64) // Pennies!
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing amount: %v", err)
+	}
+
+	occurred_at, err := time.ParseInLocation("02 Jan 06 15:04:05", strings.TrimSpace(record[OCCURREDAT]), loc)
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing occured_at: %v", err)
+	}
+
+	txn := &Txn{
+		TxnType: strings.TrimSpace(record[TXNTYPE]),
+		Amount: amount, // Remember, in pennies.
+		Description: strings.TrimSpace(record[DESC]),
+		OccurredAt: occurred_at,
+		TxnHostType: strings.TrimSpace(record[TXNHOSTTYPE]),
+		TraceNumber:  strings.TrimSpace(record[TRACENUMBER]),
+		CombinationKey: strings.TrimSpace(record[COMBINATIONKEY]),
+		AccountGroupId: strings.TrimSpace(record[ACCOUNTGROUPID]),
+	}
+
+	return txn, nil
+}
@@ -0,0 +1,103 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+var recurringUsage = "recurring:detect [-id=accountgroupid] [-asof=yyyy-mm-dd] [-months=n] [-tolerance=fraction] [-fit=fraction]"
+
+var recurringCmd = &Command{
+	Name:    "recurring:detect",
+	Usage:   recurringUsage,
+	Summary: "Detect recurring txns (subscriptions, bills, payroll)",
+	Help: `
+Looks through each account group's txn groups for txns that repeat weekly,
+biweekly, monthly or annually for about the same amount, and stores what it
+finds (replacing what was found last time) for reports to use.
+
+Only txns in the months before -asof (default today) are considered.  A
+series that hasn't been seen for two of its cadences by then is treated as
+cancelled.  Run it after importing, or on a schedule.`,
+	Run: recurringRun,
+}
+
+var recurringAccountGroupId string
+var recurringAsOf string
+var recurringMonths int
+var recurringTolerance float64
+var recurringFit float64
+
+func init() {
+	recurringCmd.Flag.StringVar(&recurringAccountGroupId, "id", "", "Only detect series for a single account group.")
+	recurringCmd.Flag.StringVar(&recurringAsOf, "asof", "", "Detect series as of this date (default today).")
+	recurringCmd.Flag.IntVar(&recurringMonths, "months", 24, "How many months of txns to look through.")
+	recurringCmd.Flag.Float64Var(&recurringTolerance, "tolerance", 0.15, "Fraction an amount can differ from the series' typical amount.")
+	recurringCmd.Flag.Float64Var(&recurringFit, "fit", 0.75, "Fraction of a series' gaps and amounts that must fit its cadence and amount.")
+}
+
+func recurringRun(cmd *Command, args ...string) {
+
+	if recurringMonths < 1 || recurringTolerance < 0 || recurringFit <= 0 || recurringFit > 1 {
+		printError("-months must be at least 1, -tolerance can't be negative and -fit must be between 0 and 1\n", recurringUsage)
+		return
+	}
+
+	loc, err := loadLocation("")
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	asOf := time.Now().In(loc)
+	if recurringAsOf != "" {
+		asOf, err = time.ParseInLocation(shortForm, recurringAsOf+" 23:59:59", loc)
+		if err != nil {
+			printError("Bad -asof date, expected yyyy-mm-dd\n", recurringUsage)
+			return
+		}
+	}
+
+	opts := &RecurringOptions{
+		AmountTolerance: recurringTolerance,
+		MinFit:          recurringFit,
+		AsOf:            asOf,
+		Location:        loc,
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	defer timeTrack(time.Now(), "Recurring detection")
+
+	total, err := detect_all_recurring(recurringAccountGroupId, asOf.AddDate(0, -recurringMonths, 0), opts, dbm,
+		func(agid string, found int) {
+			log.Printf("Found %d recurring series for %s", found, agid)
+		})
+
+	if err != nil {
+		log.Printf("Error detecting recurring txns: %v", err)
+		return
+	}
+
+	log.Printf("Found %d recurring series in total", total)
+}
@@ -24,19 +24,71 @@ import (
 	"time"
 	"strings"
 	"path"
-	"html/template"
 	"os"
 	"bufio"
+	"sync"
+	"io"
+	"errors"
+	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
+	"bytes"
 )
 
-var reportUsage = "report [-membersfile=filename | -id=accountgroupid] | -system] -output=path -start=yyyy-mm-dd -end=yyyy-mm-dd template"
+var reportUsage = "report [-membersfile=filename | -id=accountgroupid] | -system] [-parallel=n] -output=path (-start=yyyy-mm-dd -end=yyyy-mm-dd | -period=name [-asof=yyyy-mm-dd] [-fiscalstart=month]) [-locale=en-CA] [-engine=html|text] [-templatedir=dir [-render=name]] [-cohortfile=filename] [-bundle=file.zip|file.tar.gz [-templateversion=v]] [-format=pdf [-pagesize=letter|legal|a4] | -format=xlsx | -format=json|csv [-top=n] [-interval=month]] template"
 
 var reportCmd = &Command{
 	Name:    "report",
 	Usage:   reportUsage,
 	Summary: "Generate reports",
 	Help:    `
-One of membersfile, system or id is required as is template.`,
+One of membersfile, system or id is required as is template.
+
+The period is given by -start and -end or by -period, one of last-month,
+month-to-date, last-quarter, quarter-to-date, ytd, last-year,
+last-12-months, fiscal-ytd or last-fiscal-year, worked out as of today (or
+-asof).  Fiscal years start on the first of -fiscalstart (1 to 12, 1 by
+default).
+
+A report is written to the output directory for each account group id in
+the members file (one id per line) as <id>.<template extension>.  The system
+report covers the whole FI and is written as system.<template extension>.
+
+With -format=json or -format=csv no template is needed, the report's data
+(the period, summaries by txn type, txn group type and classification, the
+top -top txn groups and trends by -interval) is written as <id>.json or
+<id>.csv instead.
+
+-format=xlsx writes an Excel workbook, <id>.xlsx, of the member's txns for
+the period (left out of the system report) with summary sheets by txn group
+type and classification.
+
+With -format=pdf the template is rendered as HTML and laid out as a PDF,
+written as <id>.pdf on -pagesize pages (letter, legal or a4, letter by
+default).  Headings, paragraphs, lists, tables, inline styles and charts are
+supported, see the Authoring Reports guide.
+
+Templates format amounts, numbers and dates for -locale: en-CA (the
+default), en-US or fr-CA.
+
+Templates for html, htm, xhtml, svg and xml files are rendered with
+html/template, which escapes data for HTML.  Others (txt, csv, md, tex...)
+are rendered with text/template, as is.  -engine=html or -engine=text
+overrides the choice.
+
+With -templatedir, the templates in the directory's layouts and partials
+subdirectories are loaded along with the template (which is looked for in
+the directory too) so it can use them.  -render names the template to
+render, a layout or a define block, rather than the template itself.
+
+Benchmarks compare each member's spending with every member's, or with the
+members listed in -cohortfile (one id per line, like -membersfile).
+
+-bundle packages the rendered reports into a zip or tar.gz file along with
+manifest.csv, listing every report's account group id, file name, SHA-256
+checksum, period, template version and whether it rendered.  The template
+version is -templateversion, or by default a checksum of the template (and
+its layouts and partials).`,
 	Run:     reportRun,
 }
 
@@ -46,6 +98,21 @@ var systemReport bool
 var outputDir string
 var startDate  string
 var endDate  string
+var parallelReports int
+var reportFormat string
+var pageSize string
+var datasetTop int
+var datasetInterval string
+var reportLocale string
+var reportEngine string
+var templateDir string
+var renderTemplate string
+var reportPeriod string
+var reportAsOf string
+var fiscalStart int
+var cohortFile string
+var bundleFile string
+var templateVersion string
 
 func init() {
 	reportCmd.Flag.StringVar(&membersFile, "membersfile", "", "A file containing a list of account group ids.")
@@ -54,6 +121,21 @@ func init() {
 	reportCmd.Flag.StringVar(&outputDir, "output", ".", "Directory to store generated reports in.")
 	reportCmd.Flag.StringVar(&startDate, "start", "", "Sets the start date to report on.")
 	reportCmd.Flag.StringVar(&endDate, "end", "", "Sets the end date to report on.")
+	reportCmd.Flag.IntVar(&parallelReports, "parallel", 1, "Number of reports to render at once.")
+	reportCmd.Flag.StringVar(&reportFormat, "format", "", "Write the report as a pdf, or its data as xlsx, json or csv instead of rendering a template.")
+	reportCmd.Flag.StringVar(&pageSize, "pagesize", "letter", "Page size of pdf reports (letter, legal or a4).")
+	reportCmd.Flag.IntVar(&datasetTop, "top", 10, "Number of top txn groups in json or csv data.")
+	reportCmd.Flag.StringVar(&datasetInterval, "interval", "month", "Interval of the trends in json or csv data (day, week, month or year).")
+	reportCmd.Flag.StringVar(&reportLocale, "locale", defaultLocale, "Locale templates format amounts and dates for (en-CA, en-US or fr-CA).")
+	reportCmd.Flag.StringVar(&reportEngine, "engine", "", "Template engine, html or text (by default html for .html, .htm, .xhtml, .svg and .xml templates, text otherwise).")
+	reportCmd.Flag.StringVar(&templateDir, "templatedir", "", "Directory of shared layouts and partials (in layouts/ and partials/ subdirectories).")
+	reportCmd.Flag.StringVar(&renderTemplate, "render", "", "Name of the template to render, the template file by default.")
+	reportCmd.Flag.StringVar(&reportPeriod, "period", "", "Report on a named period instead of -start and -end (last-month, last-quarter, ytd, last-12-months, fiscal-ytd...).")
+	reportCmd.Flag.StringVar(&reportAsOf, "asof", "", "Work -period out as of this date rather than today.")
+	reportCmd.Flag.IntVar(&fiscalStart, "fiscalstart", 1, "Month (1 to 12) fiscal years start in.")
+	reportCmd.Flag.StringVar(&cohortFile, "cohortfile", "", "A file of the account group ids benchmarks compare with, all members by default.")
+	reportCmd.Flag.StringVar(&bundleFile, "bundle", "", "Package the reports with a manifest into this .zip or .tar.gz file.")
+	reportCmd.Flag.StringVar(&templateVersion, "templateversion", "", "Template version for the bundle manifest, a checksum of the template by default.")
 }
 
 func reportRun(cmd *Command, args ...string) {
@@ -63,17 +145,90 @@ func reportRun(cmd *Command, args ...string) {
 		return
 	}
 
-	if endDate == "" || startDate == "" {
-		printError("Missing option: -start and -end are required\n",reportUsage)
+	if reportPeriod == "" && (endDate == "" || startDate == "") {
+		printError("Missing option: -start and -end or -period are required\n",reportUsage)
+		return
+	}
+
+	if reportPeriod != "" && (endDate != "" || startDate != "") {
+		printError("-period can't be given with -start or -end\n",reportUsage)
+		return
+	}
+
+	switch reportFormat {
+	case "", "pdf", "xlsx", "json", "csv":
+	default:
+		printError("Unknown -format, expected pdf, xlsx, json or csv\n", reportUsage)
 		return
 	}
 
-	if len(args) == 0 {
+	var dataset = reportFormat == "xlsx" || reportFormat == "json" || reportFormat == "csv"
+
+	if _, ok := pdfPageSizes[pageSize]; !ok {
+		printError("Unknown -pagesize, expected letter, legal or a4\n", reportUsage)
+		return
+	}
+
+	if dataset && (datasetTop < 0 || !validInterval(datasetInterval)) {
+		printError("-top can't be negative and -interval must be day, week, month or year\n", reportUsage)
+		return
+	}
+
+	if len(args) == 0 && !dataset {
 		printError("Missing template filename\n", reportUsage)
 		return
 	}
 
-	var report = args[0]
+	if parallelReports < 1 {
+		printError("-parallel must be at least 1\n", reportUsage)
+		return
+	}
+
+	if bundleFile != "" {
+		if _, err := bundleFormat(bundleFile); err != nil {
+			printError(err.Error() + "\n", reportUsage)
+			return
+		}
+	}
+
+	locale, err := findLocale(reportLocale)
+	if err != nil {
+		printError(err.Error() + "\n", reportUsage)
+		return
+	}
+
+	loc, err := loadLocation("")
+	if err != nil {
+		printError(err.Error() + "\n", reportUsage)
+		return
+	}
+
+	reportStart, reportEnd, err := reportDates(loc)
+	if err != nil {
+		printError(err.Error() + "\n", reportUsage)
+		return
+	}
+
+	ids, err := reportIds()
+	if err != nil {
+		printError(err.Error() + "\n", reportUsage)
+		return
+	}
+
+	// Shared by every report, so the cohort's spending is only queried once
+	var cohort = allMembers
+	if cohortFile != "" {
+		members, err := readMembersFile(cohortFile)
+		if err != nil {
+			printError(err.Error() + "\n", reportUsage)
+			return
+		}
+		if len(members) == 0 {
+			printError("No account group ids in " + cohortFile + "\n", reportUsage)
+			return
+		}
+		cohort = NewCohort(path.Base(cohortFile), members)
+	}
 
 	dbm := initDb()
 	defer dbm.Db.Close()
@@ -84,45 +239,312 @@ func reportRun(cmd *Command, args ...string) {
 
 	defer timeTrack(time.Now(), "Generate Reports")
 
-	log.Printf("Generating reports using '%s'", report)
+	var render func(api *ReportingApi) *RenderResult
+
+	bundle := &BundleInfo{
+		PeriodStart: reportStart.Format("2006-01-02"),
+		PeriodEnd: reportEnd.Format("2006-01-02"),
+		TemplateVersion: templateVersion,
+	}
+
+	if dataset {
+		log.Printf("Writing report data as %s", reportFormat)
+
+		bundle.Template = reportFormat
+
+		render = func(api *ReportingApi) *RenderResult {
+			return renderDatasetToFile(reportFormat, api)
+		}
+	} else {
+		var report = args[0]
+
+		log.Printf("Generating reports using '%s'", report)
+
+		var pieces = strings.Split(report, ".")
+		// Don't care about what's in-between really 
+		// ext is just for the output file to match the template
+		var ext = pieces[len(pieces) - 1] 
+
+		// PDFs are laid out from HTML, whatever the template's extension
+		var templateExt = ext
+		if reportFormat == "pdf" {
+			templateExt = "html"
+		}
+
+		engine, err := templateEngine(reportEngine, templateExt)
+		if err != nil {
+			printError(err.Error() + "\n", reportUsage)
+			return
+		}
+
+		log.Printf("Rendering with %s/template", engine)
+
+		t, err := parseReportTemplate(report, templateDir, renderTemplate, engine, reportFuncMap(locale))
+		if err != nil {
+			printError(err.Error() + "\n", reportUsage)
+			return
+		}
+
+		bundle.Template = path.Base(report)
+		if bundle.TemplateVersion == "" {
+			if bundle.TemplateVersion, err = reportTemplateVersion(report, templateDir); err != nil {
+				printError(err.Error() + "\n", reportUsage)
+				return
+			}
+		}
+
+		render = func(api *ReportingApi) *RenderResult {
+			if reportFormat == "pdf" {
+				return renderPDFToFile(t, api)
+			}
+			return renderReportToFile(t, ext, api)
+		}
+	}
+
+	log.Printf("Reporting on %s to %s", reportStart.Format("2006-01-02"), reportEnd.Format("2006-01-02"))
+
+	results := renderReports(ids, parallelReports, func(id string) *RenderResult {
+		var api = &ReportingApi{PeriodStart: reportStart, PeriodEnd: reportEnd, Location: loc, AccountGroupId: id, Cohort: cohort, dbmap: dbm}
+		return render(api)
+	})
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("Failed %s: %v", result.File, result.Err)
+			failed++
+		}
+	}
+
+	log.Printf("Rendered %d reports, %d failed", len(results) - failed, failed)
+
+	if bundleFile != "" {
+		if err := writeBundle(bundleFile, bundle, results); err != nil {
+			log.Printf("Error writing bundle %s: %v", bundleFile, err)
+			return
+		}
+		log.Printf("Bundled the reports in %s", bundleFile)
+	}
+}
+
+// The outcome of rendering the report for one account group
+type RenderResult struct {
+	AccountGroupId string // Empty for the system report
+	File string
+	Checksum string // Hex SHA-256 of what was written
+	Bytes int64
+	Err error
+}
+
+// Collect the account group ids to report on from the -id, -membersfile and
+// -system options, each once.  The system (FI wide) report has the empty id.
+func reportIds() ([]string, error) {
+
+	var ids []string
+
+	if accountGroupId != "" {
+		if err := checkAccountGroupId(accountGroupId); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, accountGroupId)
+	}
+
+	if membersFile != "" {
+		members, err := readMembersFile(membersFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range members {
+			if id != accountGroupId {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	if systemReport {
+		ids = append(ids, "")
+	}
+
+	return ids, nil
+}
 
-	var pieces = strings.Split(report, ".")
-	// Don't care about what's in-between really 
-	// ext is just for the output file to match the template
-	var ext = pieces[len(pieces) - 1] 
+// Read account group ids from a file, one per line.  Blank lines and lines
+// starting with # are skipped.  An id listed twice is an error, as its two
+// reports would be written to the same file.
+func readMembersFile(filename string) ([]string, error) {
 
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	var t = template.Must(template.New(report).Funcs(template.FuncMap{"currency": currency, 
-		"capitalize": capitalize}).ParseFiles(report))
+	var ids []string
+	lines := map[string]int{}
 
-	reportStart, _ := time.Parse(shortForm, startDate + " 00:00:00")
-	reportEnd, _ := time.Parse(shortForm, endDate + " 23:59:59")
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" || strings.HasPrefix(id, "#") {
+			continue
+		}
 
-	// TODO Add support for reading id's from a file.
+		if err := checkAccountGroupId(id); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
 
-	// TODO Add support for running the report for the organization.
+		if first, ok := lines[id]; ok {
+			return nil, fmt.Errorf("%s:%d: account group id '%s' is already listed on line %d", filename, line, id, first)
+		}
+		lines[id] = line
 
-	var id = accountGroupId
+		ids = append(ids, id)
+	}
 
-	var api = &ReportingApi{reportStart, reportEnd, id, dbm}
+	return ids, scanner.Err()
+}
 
-	// Do work.... TODO Lame, only supports a single id at the moment....
-	renderReportToFile(t, ext, api)
+// Account group ids name report files, so they can't be allowed to reach
+// outside the output directory or to take the system report's name
+func checkAccountGroupId(id string) error {
+	if strings.ContainsAny(id, "/\\") || strings.Contains(id, "..") {
+		return fmt.Errorf("invalid account group id '%s': ids can't contain path separators or '..'", id)
+	}
+	if strings.EqualFold(id, "system") {
+		return fmt.Errorf("invalid account group id '%s': it's reserved for the system report", id)
+	}
+	return nil
 }
 
-func renderReportToFile(t *template.Template, ext string, api *ReportingApi) {
+// Render a report for each id using a pool of workers, returning the
+// results in the same order as ids.
+func renderReports(ids []string, workers int, render func(id string) *RenderResult) []*RenderResult {
+
+	results := make([]*RenderResult, len(ids))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = render(ids[i])
+			}
+		}()
+	}
+
+	for i := range ids {
+		jobs <- i
+	}
+	close(jobs)
+
+	wg.Wait()
 
-	var output = path.Join(outputDir, api.AccountGroupId + "." + ext);
+	return results
+}
+
+// Return the start and end of the report period, from -start and -end or
+// -period
+func reportDates(loc *time.Location) (time.Time, time.Time, error) {
+	return periodDates(reportPeriod, reportAsOf, startDate, endDate, time.Month(fiscalStart), loc)
+}
+
+func renderReportToFile(t reportTemplate, ext string, api *ReportingApi) *RenderResult {
+	return writeReportFile(ext, api, func(w io.Writer) error {
+		return t.Execute(w, api)
+	})
+}
+
+// Render the template as HTML and lay it out as a PDF
+func renderPDFToFile(t reportTemplate, api *ReportingApi) *RenderResult {
+	return writeReportFile("pdf", api, func(w io.Writer) error {
+		var page bytes.Buffer
+		if err := t.Execute(&page, api); err != nil {
+			return err
+		}
+		return htmlToPDF(&page, w, pageSize)
+	})
+}
+
+// Write the report's dataset as json or csv, or its txns and summaries as
+// an xlsx workbook
+func renderDatasetToFile(format string, api *ReportingApi) *RenderResult {
+	return writeReportFile(format, api, func(w io.Writer) error {
+		if format == "xlsx" {
+			return api.writeXLSX(w)
+		}
+
+		dataset := api.Dataset(datasetTop, datasetInterval)
+		if dataset == nil {
+			return errors.New("couldn't query the report data")
+		}
+
+		if format == "csv" {
+			return dataset.WriteCSV(w)
+		}
+		return dataset.WriteJSON(w)
+	})
+}
+
+// Create the output file for an account group's report (system.<ext> for
+// the system report) and write it
+func writeReportFile(ext string, api *ReportingApi, write func(w io.Writer) error) *RenderResult {
+
+	var name = api.AccountGroupId
+	if api.IsSystem() {
+		name = "system"
+	}
+
+	var output = path.Join(outputDir, name + "." + ext);
 	log.Printf("Generating %s", output)
 
+	result := &RenderResult{AccountGroupId: api.AccountGroupId, File: output}
+
 	f, err := os.Create(output)
 	if err != nil {
-		log.Printf("Error creating '%s': %v", output, err)
-		return
+		result.Err = err
+		return result
 	}
-	defer f.Close()
 
-	w := bufio.NewWriter(f)
-	t.Execute(w, api)
-	w.Flush()
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(f, hash)}
+
+	w := bufio.NewWriter(counter)
+	if err := write(w); err != nil {
+		f.Close()
+		result.Err = err
+		return result
+	}
+
+	// The report isn't written until it's flushed and closed without error
+	err = w.Flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Checksum = hex.EncodeToString(hash.Sum(nil))
+	result.Bytes = counter.n
+
+	return result
+}
+
+// Counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -0,0 +1,100 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+var summariesUsage = "summaries:rebuild [-id=accountgroupid | -clear]"
+
+var summariesCmd = &Command{
+	Name:    "summaries:rebuild",
+	Usage:   summariesUsage,
+	Summary: "Rebuild the monthly txn summaries reports read",
+	Help: `
+Totals every account group's txns by month (in -timezone), txn type, txn
+group and classification.  Once built, imports keep the summaries up to date
+and reports on whole months (such as -period=last-month) read them rather
+than the txns, which is much faster when rendering many reports.
+
+Run it once to start using summaries, and again if txns are changed other
+than by importing.  -id rebuilds a single account group's.  -clear drops the
+summaries, so reports go back to reading txns.`,
+	Run: summariesRun,
+}
+
+var summariesAccountGroupId string
+var summariesClear bool
+
+func init() {
+	summariesCmd.Flag.StringVar(&summariesAccountGroupId, "id", "", "Only rebuild the summaries of a single account group.")
+	summariesCmd.Flag.BoolVar(&summariesClear, "clear", false, "Drop the summaries instead.")
+}
+
+func summariesRun(cmd *Command, args ...string) {
+
+	if summariesClear && summariesAccountGroupId != "" {
+		printError("-clear can't be given with -id\n", summariesUsage)
+		return
+	}
+
+	loc, err := loadLocation("")
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	if summariesClear {
+		if err := clear_summaries(loc, dbm); err != nil {
+			log.Printf("Error clearing summaries: %v", err)
+			return
+		}
+		log.Printf("Cleared the %s summaries", loc)
+		return
+	}
+
+	if summariesAccountGroupId != "" {
+		// Other account groups would be missing from a partial build
+		built, err := summaries_built(loc, dbm)
+		if err != nil {
+			log.Printf("Error rebuilding summaries: %v", err)
+			return
+		}
+		if !built {
+			printError("No "+loc.String()+" summaries yet, rebuild them all first\n", summariesUsage)
+			return
+		}
+	}
+
+	defer timeTrack(time.Now(), "Rebuild summaries")
+
+	rows, err := rebuild_summaries(loc, summariesAccountGroupId, dbm)
+	if err != nil {
+		log.Printf("Error rebuilding summaries: %v", err)
+		return
+	}
+
+	log.Printf("Wrote %d %s summary rows", rows, loc)
+}
@@ -0,0 +1,375 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+var watchUsage = "watch [-inbox=dir] [-profiles=filename] [-interval=30s] [-settle=10s] [-once]"
+
+var watchCmd = &Command{
+	Name:    "watch",
+	Usage:   watchUsage,
+	Summary: "Import files as they arrive in an inbox directory",
+	Help: `
+Polls the inbox directory and imports each new file using the first profile
+whose pattern matches the file name.  Imported files are moved to
+inbox/processed, files that could not be imported to inbox/failed.  A
+<file>.result.json sidecar describing the outcome is written next to the
+moved file.
+
+Files are identified by a hash of their contents, a file that has already
+been imported is never imported again.
+
+The profiles file is a json array of:
+
+    {"Name": "core", "Pattern": "core_*.tsv", "Matchers": "conf/matchers.json",
+     "Timezone": "America/Vancouver", "Control": "trailer", "OnMismatch": "reject"}
+
+Matchers and Timezone default to the global options.  Control and OnMismatch
+work as the importcsv -control and -mismatch options (OnMismatch defaults to
+reject), a .ctl sidecar is moved along with the file it belongs to.  The
+watcher won't start if a profile has any other value.  Without a profiles file
+every file is imported with the global options.`,
+	Run: watchRun,
+}
+
+var watchInbox string
+var watchProfiles string
+var watchInterval time.Duration
+var watchSettle time.Duration
+var watchOnce bool
+
+func init() {
+	watchCmd.Flag.StringVar(&watchInbox, "inbox", "inbox", "Directory to watch for new files.")
+	watchCmd.Flag.StringVar(&watchProfiles, "profiles", "", "A json file of import profiles.")
+	watchCmd.Flag.DurationVar(&watchInterval, "interval", 30*time.Second, "How often to poll the inbox.")
+	watchCmd.Flag.DurationVar(&watchSettle, "settle", 10*time.Second, "Ignore files modified more recently than this (still being written).")
+	watchCmd.Flag.BoolVar(&watchOnce, "once", false, "Poll the inbox once and exit.")
+}
+
+// Describes how to import files whose name matches Pattern
+type ImportProfile struct {
+	Name     string
+	Pattern  string // filepath.Match pattern on the file name
+	Matchers string
+	Timezone string
+
+	Control    string // trailer || sidecar, empty to skip control totals
+	OnMismatch string // reject || flag
+
+	matchSet MatchSet
+	location *time.Location
+}
+
+// The outcome of ingesting a file, written alongside it as <file>.result.json
+type WatchResult struct {
+	File     string
+	FileHash string
+	Profile  string
+
+	Status   string // imported || duplicate || failed
+	Imported int
+	Errors   int
+	Message  string `json:",omitempty"`
+
+	ControlStatus string `json:",omitempty"` // matched || mismatched
+
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+const sidecarSuffix = ".result.json"
+
+func watchRun(cmd *Command, args ...string) {
+
+	profiles, err := load_import_profiles(watchProfiles)
+	if err != nil {
+		log.Println("Error:", err)
+		return
+	}
+
+	for _, dir := range []string{"processed", "failed"} {
+		if err := os.MkdirAll(filepath.Join(watchInbox, dir), 0755); err != nil {
+			log.Println("Error:", err)
+			return
+		}
+	}
+
+	dbm := initDb()
+	defer dbm.Db.Close()
+
+	log.Printf("DB Connected.")
+	log.Printf("Watching '%s'", watchInbox)
+
+	for {
+		pollInbox(profiles, dbm)
+
+		if watchOnce {
+			return
+		}
+
+		time.Sleep(watchInterval)
+	}
+}
+
+// Import each settled file currently in the inbox
+func pollInbox(profiles []*ImportProfile, dbm *gorp.DbMap) {
+
+	entries, err := ioutil.ReadDir(watchInbox)
+	if err != nil {
+		log.Printf("Error reading inbox: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, sidecarSuffix) || strings.HasSuffix(name, controlSuffix) {
+			continue
+		}
+
+		if time.Since(entry.ModTime()) < watchSettle {
+			continue // Probably still being copied in, pick it up next time.
+		}
+
+		result := ingestFile(filepath.Join(watchInbox, name), profiles, dbm)
+
+		dest := "processed"
+		if result.Status == "failed" {
+			dest = "failed"
+		}
+
+		if err := moveWithSidecar(filepath.Join(watchInbox, name), filepath.Join(watchInbox, dest), result); err != nil {
+			log.Printf("Error moving '%s' to %s: %v", name, dest, err)
+		}
+
+		if ctl := find_control_file(filepath.Join(watchInbox, name)); ctl != "" {
+			if err := os.Rename(ctl, filepath.Join(watchInbox, dest, filepath.Base(ctl))); err != nil {
+				log.Printf("Error moving '%s' to %s: %v", ctl, dest, err)
+			}
+		}
+
+		log.Printf("%s: %s (%d imported, %d bad records) %s", name, result.Status, result.Imported, result.Errors, result.Message)
+	}
+}
+
+// Import a single file from the inbox, recording it by content hash
+func ingestFile(filename string, profiles []*ImportProfile, dbm *gorp.DbMap) *WatchResult {
+
+	result := &WatchResult{File: filepath.Base(filename), StartedAt: time.Now()}
+	defer func() { result.FinishedAt = time.Now() }()
+
+	fail := func(err error) *WatchResult {
+		result.Status = "failed"
+		result.Message = err.Error()
+		return result
+	}
+
+	hash, err := fileHash(filename)
+	if err != nil {
+		return fail(err)
+	}
+	result.FileHash = hash
+
+	profile := find_import_profile(profiles, result.File)
+	if profile == nil {
+		return fail(fmt.Errorf("no import profile matches '%s'", result.File))
+	}
+	result.Profile = profile.Name
+
+	record := ImportFile{}
+	err = dbm.SelectOne(&record, "SELECT * FROM import_files WHERE file_hash = :hash",
+		map[string]interface{}{"hash": hash})
+
+	if err == nil && record.Status == "imported" {
+		result.Status = "duplicate"
+		result.Message = fmt.Sprintf("already imported as '%s'", record.FileName)
+		return result
+	} else if err != nil && err != sql.ErrNoRows {
+		return fail(err)
+	}
+
+	inputs, cleanup, err := open_import_inputs(filename)
+	defer cleanup()
+	if err != nil {
+		return fail(err)
+	}
+
+	opts := ImportOptions{
+		Location: profile.location,
+		Matchers: profile.matchSet,
+		BatchSize: defaultBatchSize,
+		Resume: true, // Pick up where a failed attempt left off
+		Control: profile.Control,
+		RejectMismatch: profile.OnMismatch != "flag",
+	}
+
+	result.Status = "imported"
+
+	for _, input := range inputs {
+		imported, err := import_input(input, opts, dbm)
+
+		result.Imported += imported.Imported
+		result.Errors += imported.Errors
+
+		if imported.ControlStatus != "" && result.ControlStatus != "mismatched" {
+			result.ControlStatus = imported.ControlStatus
+		}
+
+		if err != nil {
+			result.Status = "failed"
+			result.Message = fmt.Sprintf("%s: %v", input.Name, err)
+			break
+		}
+	}
+
+	record.FileHash = hash
+	record.FileName = result.File
+	record.Profile = result.Profile
+	record.Status = result.Status
+	record.Imported = result.Imported
+	record.Errors = result.Errors
+
+	if record.Id != 0 { // A previous attempt failed
+		_, err = dbm.Update(&record)
+	} else {
+		record.Created = time.Now().UnixNano()
+		err = dbm.Insert(&record)
+	}
+
+	if err != nil {
+		log.Printf("Error recording import of '%s': %v", result.File, err)
+	}
+
+	return result
+}
+
+// Move filename into dir and write the result sidecar next to it
+func moveWithSidecar(filename string, dir string, result *WatchResult) error {
+
+	dest := filepath.Join(dir, filepath.Base(filename))
+
+	if _, err := os.Stat(dest); err == nil {
+		// Don't clobber an earlier file with the same name
+		dest = fmt.Sprintf("%s.%d", dest, time.Now().Unix())
+	}
+
+	if err := os.Rename(filename, dest); err != nil {
+		return err
+	}
+
+	sidecar, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dest+sidecarSuffix, sidecar, 0644)
+}
+
+// Return the hex encoded sha256 of the file's contents
+func fileHash(filename string) (string, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Return the first profile matching the file name, or nil
+func find_import_profile(profiles []*ImportProfile, name string) *ImportProfile {
+	for _, profile := range profiles {
+		if ok, _ := filepath.Match(profile.Pattern, name); ok {
+			return profile
+		}
+	}
+
+	return nil
+}
+
+// Load the import profiles from filename, resolving their matchers and
+// timezones.  With no filename a single catch-all profile is returned.
+func load_import_profiles(filename string) ([]*ImportProfile, error) {
+
+	profiles := []*ImportProfile{{Name: "default", Pattern: "*"}}
+
+	if filename != "" {
+		file, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = nil
+		if err := json.Unmarshal(file, &profiles); err != nil {
+			return nil, fmt.Errorf("reading profiles '%s': %v", filename, err)
+		}
+	}
+
+	for _, profile := range profiles {
+		if _, err := filepath.Match(profile.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("profile '%s': bad pattern %q", profile.Name, profile.Pattern)
+		}
+
+		if profile.Matchers == "" {
+			profile.Matchers = *flagMatchers
+		}
+		profile.matchSet = match_set_from_file(profile.Matchers)
+
+		if profile.Control != "" && profile.Control != "trailer" && profile.Control != "sidecar" {
+			return nil, fmt.Errorf("profile '%s': Control must be trailer or sidecar, not %q", profile.Name, profile.Control)
+		}
+
+		if profile.OnMismatch == "" {
+			profile.OnMismatch = "reject"
+		} else if profile.OnMismatch != "reject" && profile.OnMismatch != "flag" {
+			return nil, fmt.Errorf("profile '%s': OnMismatch must be reject or flag, not %q", profile.Name, profile.OnMismatch)
+		}
+
+		loc, err := loadLocation(profile.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("profile '%s': %v", profile.Name, err)
+		}
+		profile.location = loc
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,223 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extracts may end with a trailer record stating their control totals:
+//
+//    TRAILER <tab> record count <tab> debit total <tab> credit total
+//
+// or come with a <file>.ctl sidecar containing the same as key=value lines:
+//
+//    records=1000
+//    debits=1234567
+//    credits=7654321
+const trailerTag = "TRAILER"
+const controlSuffix = ".ctl"
+
+// Record count and debit/credit totals for an extract
+type ControlTotals struct {
+	Records int64
+	Debits  int64 // Sum of W amounts, in pennies
+	Credits int64 // Sum of D amounts, in pennies
+}
+
+// Return a description of each way actual differs from c, empty if they agree
+func (c *ControlTotals) Discrepancies(actual *ControlTotals) []string {
+
+	var diffs []string
+
+	check := func(name string, expected, got int64) {
+		if expected != got {
+			diffs = append(diffs, fmt.Sprintf("%s: expected %d, got %d (off by %d)", name, expected, got, got-expected))
+		}
+	}
+
+	check("records", c.Records, actual.Records)
+	check("debits", c.Debits, actual.Debits)
+	check("credits", c.Credits, actual.Credits)
+
+	return diffs
+}
+
+func is_trailer(record []string) bool {
+	return len(record) > 0 && strings.TrimSpace(record[0]) == trailerTag
+}
+
+// Read the control totals out of a trailer record
+func parse_trailer(record []string) (*ControlTotals, error) {
+
+	if len(record) < 4 {
+		return nil, fmt.Errorf("trailer record has %d fields, expected 4", len(record))
+	}
+
+	totals := &ControlTotals{}
+	fields := []*int64{&totals.Records, &totals.Debits, &totals.Credits}
+
+	for i, field := range fields {
+		n, err := strconv.ParseInt(strings.TrimSpace(record[i+1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad trailer record: %v", err)
+		}
+		*field = n
+	}
+
+	return totals, nil
+}
+
+// Read the control totals out of a sidecar file
+func parse_control_file(r io.Reader) (*ControlTotals, error) {
+
+	totals := &ControlTotals{}
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pieces := strings.SplitN(line, "=", 2)
+		if len(pieces) != 2 {
+			return nil, fmt.Errorf("bad control file line %q", line)
+		}
+
+		key := strings.ToLower(strings.TrimSpace(pieces[0]))
+		n, err := strconv.ParseInt(strings.TrimSpace(pieces[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad control file line %q: %v", line, err)
+		}
+
+		switch key {
+		case "records":
+			totals.Records = n
+		case "debits":
+			totals.Debits = n
+		case "credits":
+			totals.Credits = n
+		default:
+			continue // Cores like to add their own fields, ignore them
+		}
+		seen[key] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{"records", "debits", "credits"} {
+		if !seen[key] {
+			return nil, fmt.Errorf("control file is missing %s", key)
+		}
+	}
+
+	return totals, nil
+}
+
+// Read the whole input, totalling what parses and comparing it to the
+// input's control totals.  Returns the control totals, nil if there are none,
+// and the discrepancies found (a missing trailer or sidecar counts as one, a
+// truncated transfer looks just like that).
+func check_control_totals(input *ImportInput, control string, loc *time.Location) (*ControlTotals, []string, error) {
+
+	var expected *ControlTotals
+	actual := &ControlTotals{}
+
+	if control == "sidecar" {
+		if input.control == nil {
+			return nil, []string{"no " + controlSuffix + " control file found"}, nil
+		}
+
+		r, err := input.control()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		expected, err = parse_control_file(r)
+		r.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if control != "trailer" {
+		return nil, nil, fmt.Errorf("unknown control total source %q (expected trailer or sidecar)", control)
+	}
+
+	r, err := input.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	reader := csv.NewReader(r)
+	reader.Comma = '\t'
+	reader.FieldsPerRecord = -1
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if is_trailer(record) {
+			if control == "trailer" {
+				if expected, err = parse_trailer(record); err != nil {
+					return nil, nil, err
+				}
+			}
+			continue
+		}
+
+		if control == "trailer" && expected != nil {
+			return expected, []string{"records found after the trailer record"}, nil
+		}
+
+		actual.Records++
+
+		txn, err := parse_record(record, loc)
+		if err != nil {
+			continue // Bad records still count, but can't add to the totals
+		}
+
+		switch txn.TxnType {
+		case "W":
+			actual.Debits += txn.Amount
+		case "D":
+			actual.Credits += txn.Amount
+		}
+	}
+
+	if expected == nil {
+		return nil, []string{"no " + trailerTag + " record found"}, nil
+	}
+
+	return expected, expected.Discrepancies(actual), nil
+}
@@ -76,3 +76,111 @@ type TxnGroup struct {
 	
 	Created int64 // Init with time.Now().UnixNano
 }
+
+// A member defined spending category with an optional budget.  Txn groups
+// (and so their txns) are assigned to categories.
+type Category struct {
+	Id int64
+	Label string
+
+	SpendingLimit int64 `db:"spending_limit"` // Pennies per interval, 0 for no limit
+	LimitInterval int `db:"limit_interval"` // Days, see LimitWeekly etc
+
+	AccountGroupId string `db:"account_group_id"`
+
+	Created int64 // Init with time.Now().Unix
+}
+
+// Limit intervals that follow the calendar rather than counting days
+const (
+	LimitDaily = 1
+	LimitWeekly = 7
+	LimitMonthly = 30
+	LimitYearly = 365
+)
+
+// A run of txns in a txn group that repeat on a regular cadence, a
+// subscription or regular bill (or a regular deposit such as payroll).
+type RecurringSeries struct {
+	Id int64
+	AccountGroupId string `db:"account_group_id"`
+	TxnGroupId int64 `db:"txn_group_id"`
+	TxnType string `db:"txn_type"`
+
+	Label string
+	TxnGroupType string `db:"txn_group_type"`
+	Classification string
+
+	Cadence string // weekly || biweekly || monthly || annual
+	Amount int64 // Typical (median) amount, pennies
+	Occurrences int
+
+	FirstSeen time.Time `db:"first_seen"`
+	LastSeen time.Time `db:"last_seen"`
+	LastAmount int64 `db:"last_amount"`
+
+	NextExpected time.Time `db:"next_expected"`
+	NextAmount int64 `db:"next_amount"`
+
+	Created int64 // Init with time.Now().UnixNano
+}
+
+// Spending that stands out from a member's own history, a single txn or a
+// month's total at a txn group or in a classification.
+type Anomaly struct {
+	Id int64
+	AccountGroupId string `db:"account_group_id"`
+	Kind string // txn || txn_group || classification
+	PeriodStart time.Time `db:"period_start"` // Start of the month
+
+	TxnId int64 `db:"txn_id"`
+	TxnGroupId int64 `db:"txn_group_id"`
+	Classification string
+	Label string
+	OccurredAt time.Time `db:"occurred_at"`
+
+	Amount int64 // Pennies
+	Usual int64 // Median of the history, pennies
+	Ratio float64 // Amount over usual
+	Score float64 // Deviations from usual
+
+	Created int64 // Init with time.Now().UnixNano
+}
+
+// A file that has been ingested, keyed by the hash of its contents so the
+// same extract is never imported twice.
+type ImportFile struct {
+	Id int64
+	FileHash string `db:"file_hash"`
+	FileName string `db:"file_name"`
+	Profile string
+
+	Status string // imported || failed
+	Imported int
+	Errors int
+
+	Created int64 // Init with time.Now().UnixNano
+}
+
+// Progress through an import file, committed along with each batch of txns so
+// an interrupted import can be resumed where it left off.
+type ImportCheckpoint struct {
+	FileHash string `db:"file_hash"`
+	FileName string `db:"file_name"`
+
+	Records int64 // Records read so far, good and bad
+	ByteOffset int64 `db:"byte_offset"` // Offset of the next record in the file
+
+	Imported int
+	Errors int
+	Completed bool
+
+	// Totals of the txns imported, for checking against control totals
+	ImportedDebits int64 `db:"imported_debits"`
+	ImportedCredits int64 `db:"imported_credits"`
+
+	ControlStatus string `db:"control_status"` // matched || mismatched, empty if not checked
+	ControlMessage string `db:"control_message"` // The discrepancy, if any
+
+	Updated int64 // Init with time.Now().UnixNano
+}
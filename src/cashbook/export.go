@@ -0,0 +1,188 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io"
+)
+
+// The period's totals of each txn type for one value of a column
+type exportTotal struct {
+	Key     string
+	TxnType string `db:"txn_type"`
+	Count   int
+	Sum     int64
+}
+
+// Return the period's totals by txn type for each value of column (txn
+// group type or classification)
+func (r *ReportingApi) exportTotals(column string) ([]exportTotal, error) {
+
+	var totals []exportTotal
+
+	t := r.totals()
+
+	_, err := r.dbmap.Select(&totals, "SELECT "+column+" as key, txn_type, sum("+t.count+") as count, sum(amount) as sum "+
+		"FROM "+t.table+" WHERE "+r.txnScope()+" AND "+t.period+" AND "+column+" <> '' GROUP BY 1, 2 ORDER BY 1, 2",
+		r.queryParams())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// Build a workbook of the period: a summary of withdrawals and deposits,
+// the txns themselves (left out of the system report, which would have
+// everyone's) and sheets totalling them by txn group type and
+// classification.
+func (r *ReportingApi) workbook() (*xlsxWorkbook, error) {
+
+	book := &xlsxWorkbook{}
+
+	byType, err := r.exportTotals("txn_type")
+	if err != nil {
+		return nil, err
+	}
+
+	member := r.AccountGroupId
+	if r.IsSystem() {
+		member = "All members"
+	}
+
+	start, end := r.PeriodStart.In(r.location()), r.PeriodEnd.In(r.location())
+
+	summary := book.addSheet("Summary")
+	summary.addRow(xlsxHeading("Member"), xlsxText(member))
+	summary.addRow(xlsxHeading("Period start"), xlsxDay(start))
+	summary.addRow(xlsxHeading("Period end"), xlsxDay(end))
+	summary.addRow()
+
+	var net int64
+	for _, txnType := range datasetTxnTypes {
+		total := exportTotal{}
+		for _, t := range byType {
+			if t.Key == txnType {
+				total = t
+			}
+		}
+
+		label, sign := "Withdrawals", int64(-1)
+		if txnType == "D" {
+			label, sign = "Deposits", 1
+		}
+
+		summary.addRow(xlsxHeading(label), xlsxNumber(total.Count), xlsxMoney(total.Sum))
+		net += sign * total.Sum
+	}
+
+	summary.addRow(xlsxHeading("Net"), xlsxText(""), xlsxMoneyTotal(net))
+
+	if !r.IsSystem() {
+		txns, err := r.queryTxnDetails("", nil, "oldest", 0)
+		if err != nil {
+			return nil, err
+		}
+
+		sheet := book.addSheet("Transactions", "Date", "Description", "Withdrawal", "Deposit", "Txn Group", "Group Type",
+			"Classification", "Category")
+
+		for _, txn := range txns {
+			withdrawal, deposit := xlsxText(""), xlsxText("")
+			if txn.TxnType == "D" {
+				deposit = xlsxMoney(txn.Amount)
+			} else {
+				withdrawal = xlsxMoney(txn.Amount)
+			}
+
+			sheet.addRow(xlsxTime(txn.OccurredAt), xlsxText(txn.Description), withdrawal, deposit,
+				xlsxText(txn.GroupLabel), xlsxText(txn.TxnGroupType), xlsxText(txn.Classification), xlsxText(txn.Category))
+		}
+	}
+
+	for _, by := range []struct{ column, name, heading string }{
+		{"txn_group_type", "By Group Type", "Group Type"},
+		{"classification", "By Classification", "Classification"},
+	} {
+		totals, err := r.exportTotals(by.column)
+		if err != nil {
+			return nil, err
+		}
+
+		addTotalsSheet(book, by.name, by.heading, totals)
+	}
+
+	return book, nil
+}
+
+// Add a sheet of withdrawals, deposits and the net of them for each key,
+// with a total row
+func addTotalsSheet(book *xlsxWorkbook, name string, heading string, totals []exportTotal) {
+
+	sheet := book.addSheet(name, heading, "Withdrawals", "Withdrawn", "Deposits", "Deposited", "Net")
+
+	type row struct {
+		key                   string
+		withdrawals, deposits int
+		withdrawn, deposited  int64
+	}
+
+	var rows []*row
+	sums := &row{key: "Total"}
+
+	for _, t := range totals {
+		if len(rows) == 0 || rows[len(rows)-1].key != t.Key {
+			rows = append(rows, &row{key: t.Key})
+		}
+		current := rows[len(rows)-1]
+
+		if t.TxnType == "D" {
+			current.deposits += t.Count
+			current.deposited += t.Sum
+			sums.deposits += t.Count
+			sums.deposited += t.Sum
+		} else {
+			current.withdrawals += t.Count
+			current.withdrawn += t.Sum
+			sums.withdrawals += t.Count
+			sums.withdrawn += t.Sum
+		}
+	}
+
+	for _, r := range rows {
+		sheet.addRow(xlsxText(r.key), xlsxNumber(r.withdrawals), xlsxMoney(r.withdrawn), xlsxNumber(r.deposits),
+			xlsxMoney(r.deposited), xlsxMoney(r.deposited-r.withdrawn))
+	}
+
+	sheet.addRow(xlsxHeading(sums.key), xlsxNumber(sums.withdrawals), xlsxMoneyTotal(sums.withdrawn),
+		xlsxNumber(sums.deposits), xlsxMoneyTotal(sums.deposited), xlsxMoneyTotal(sums.deposited-sums.withdrawn))
+}
+
+// Write the period's workbook as an XLSX file
+func (r *ReportingApi) writeXLSX(w io.Writer) error {
+
+	book, err := r.workbook()
+	if err != nil {
+		return err
+	}
+
+	return book.Write(w)
+}
@@ -0,0 +1,420 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Return the functions available to every report template, formatting for
+// the given locale.  See the Helper functions in guide/Authoring Reports.md.
+func reportFuncMap(locale *Locale) map[string]interface{} {
+
+	funcs := map[string]interface{}{
+		// Formatting
+		"currency": func(amount interface{}) (string, error) {
+			pennies, err := toInt(amount)
+			return locale.Currency(pennies), err
+		},
+		"number": func(value interface{}, decimals ...int) (string, error) {
+			f, err := toFloat(value)
+			return locale.Number(f, firstOr(decimals, 0)), err
+		},
+		"percent": func(value interface{}, decimals ...int) (string, error) {
+			f, err := toFloat(value)
+			return locale.Percent(f, firstOr(decimals, 0)), err
+		},
+		"date": func(t time.Time, format ...string) string {
+			if len(format) == 0 {
+				return locale.Date(t, "long")
+			}
+			return locale.Date(t, format[0])
+		},
+		"capitalize": capitalize,
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+
+		// Arithmetic
+		"add":       arithmetic(func(a, b float64) float64 { return a + b }),
+		"sub":       arithmetic(func(a, b float64) float64 { return a - b }),
+		"mul":       arithmetic(func(a, b float64) float64 { return a * b }),
+		"div":       divide,
+		"percentOf": percentOf,
+		"abs":       absolute,
+		"round":     round,
+		"max":       arithmetic(math.Max),
+		"min":       arithmetic(math.Min),
+
+		// Lists
+		"sortBy":     sortBy,
+		"sortByDesc": sortByDesc,
+		"reverse":    reverse,
+		"first":      first,
+		"last":       last,
+		"sumOf":      sumOf,
+	}
+
+	for name, f := range chartFuncMap(locale) {
+		funcs[name] = f
+	}
+
+	return funcs
+}
+
+func firstOr(values []int, def int) int {
+	if len(values) == 0 {
+		return def
+	}
+	return values[0]
+}
+
+// Convert a template value (any int, uint or float, or a Summary for its
+// Sum) to a float
+func toFloat(value interface{}) (float64, error) {
+
+	if s, ok := value.(*TxnSummary); ok {
+		if s == nil {
+			return 0, nil
+		}
+		return float64(s.Sum), nil
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	}
+
+	return 0, fmt.Errorf("expected a number, got %T", value)
+}
+
+func toInt(value interface{}) (int64, error) {
+	f, err := toFloat(value)
+	return int64(math.Round(f)), err
+}
+
+// True for values that are whole numbers in Go, so arithmetic on ints
+// stays int
+func isInt(value interface{}) bool {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// Apply op across the values left to right.  The result is an int if all
+// the values are, a float otherwise.
+func arithmetic(op func(a, b float64) float64) func(first interface{}, rest ...interface{}) (interface{}, error) {
+	return func(first interface{}, rest ...interface{}) (interface{}, error) {
+
+		result, err := toFloat(first)
+		if err != nil {
+			return nil, err
+		}
+
+		ints := isInt(first)
+
+		for _, value := range rest {
+			f, err := toFloat(value)
+			if err != nil {
+				return nil, err
+			}
+			result = op(result, f)
+			ints = ints && isInt(value)
+		}
+
+		if ints {
+			return int(result), nil
+		}
+		return result, nil
+	}
+}
+
+// Divide as floats, so div 1 3 is 0.333... not 0
+func divide(a interface{}, b interface{}) (float64, error) {
+
+	x, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+
+	return x / y, nil
+}
+
+// Return part as a percentage of whole, 0 when whole is 0 so templates
+// don't have to check
+func percentOf(part interface{}, whole interface{}) (float64, error) {
+
+	x, err := toFloat(part)
+	if err != nil {
+		return 0, err
+	}
+
+	y, err := toFloat(whole)
+	if err != nil || y == 0 {
+		return 0, err
+	}
+
+	return x * 100 / y, nil
+}
+
+func absolute(value interface{}) (interface{}, error) {
+	f, err := toFloat(value)
+	if err != nil {
+		return nil, err
+	}
+	if isInt(value) {
+		return int(math.Abs(f)), nil
+	}
+	return math.Abs(f), nil
+}
+
+// Round to the given number of decimals (0 if not given)
+func round(value interface{}, decimals ...int) (float64, error) {
+	f, err := toFloat(value)
+	scale := math.Pow(10, float64(firstOr(decimals, 0)))
+	return math.Round(f*scale) / scale, err
+}
+
+// Return a sorted copy of a list of structs by the named field (or method
+// without arguments), smallest first:
+//
+//	{{range sortBy "Label" ($api.TopTxnGroups "W" 10)}}
+func sortBy(field string, list interface{}) (interface{}, error) {
+	return sortList(field, list, false)
+}
+
+// The same as sortBy, largest first
+func sortByDesc(field string, list interface{}) (interface{}, error) {
+	return sortList(field, list, true)
+}
+
+func sortList(field string, list interface{}, desc bool) (interface{}, error) {
+
+	v, err := copyList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]reflect.Value, v.Len())
+	for i := range keys {
+		if keys[i], err = fieldOf(v.Index(i), field); err != nil {
+			return nil, err
+		}
+	}
+
+	// Keys can alias the list's elements, so sort their indexes rather than
+	// moving elements about under them
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+
+	var sortErr error
+
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := keys[order[i]], keys[order[j]]
+		if desc {
+			a, b = b, a
+		}
+		less, err := lessValue(a, b)
+		if err != nil {
+			sortErr = err
+		}
+		return less
+	})
+
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	sorted := reflect.MakeSlice(v.Type(), len(order), len(order))
+	for i, index := range order {
+		sorted.Index(i).Set(v.Index(index))
+	}
+
+	return sorted.Interface(), nil
+}
+
+// Return the value of a struct's field or argumentless method
+func fieldOf(item reflect.Value, field string) (reflect.Value, error) {
+
+	if m := item.MethodByName(field); m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() >= 1 {
+		return m.Call(nil)[0], nil
+	}
+
+	for item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface {
+		if item.IsNil() {
+			return reflect.Value{}, fmt.Errorf("can't get %s of nil", field)
+		}
+		item = item.Elem()
+	}
+
+	if item.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("can't get %s of a %s", field, item.Type())
+	}
+
+	f := item.FieldByName(field)
+	if !f.IsValid() {
+		return reflect.Value{}, fmt.Errorf("%s has no field %s", item.Type(), field)
+	}
+
+	return f, nil
+}
+
+func lessValue(a, b reflect.Value) (bool, error) {
+
+	switch a.Kind() {
+	case reflect.String:
+		return a.String() < b.String(), nil
+	case reflect.Bool:
+		return !a.Bool() && b.Bool(), nil
+	}
+
+	if t, ok := a.Interface().(time.Time); ok {
+		return t.Before(b.Interface().(time.Time)), nil
+	}
+
+	x, err := toFloat(a.Interface())
+	if err != nil {
+		return false, fmt.Errorf("can't sort by a %s", a.Type())
+	}
+	y, _ := toFloat(b.Interface())
+
+	return x < y, nil
+}
+
+// Copy a slice, so sorting doesn't change what the api returned
+func copyList(list interface{}) (reflect.Value, error) {
+
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice {
+		return reflect.Value{}, fmt.Errorf("expected a list, got %T", list)
+	}
+
+	c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(c, v)
+
+	return c, nil
+}
+
+// Return a list in the opposite order
+func reverse(list interface{}) (interface{}, error) {
+
+	v, err := copyList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	reversed := v.Interface()
+	swap := reflect.Swapper(reversed)
+
+	for i, j := 0, v.Len()-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+
+	return reversed, nil
+}
+
+// Return the first n items of a list (all of them if there are fewer)
+func first(n int, list interface{}) (interface{}, error) {
+
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected a list, got %T", list)
+	}
+
+	if n < v.Len() {
+		v = v.Slice(0, int(math.Max(0, float64(n))))
+	}
+
+	return v.Interface(), nil
+}
+
+// Return the last n items of a list
+func last(n int, list interface{}) (interface{}, error) {
+
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected a list, got %T", list)
+	}
+
+	if n < v.Len() {
+		v = v.Slice(v.Len()-int(math.Max(0, float64(n))), v.Len())
+	}
+
+	return v.Interface(), nil
+}
+
+// Add up a field of a list of structs:
+//
+//	{{sumOf "Sum" ($api.TopTxnGroups "W" 5) | currency}}
+func sumOf(field string, list interface{}) (interface{}, error) {
+
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected a list, got %T", list)
+	}
+
+	total := 0.0
+	ints := true
+
+	for i := 0; i < v.Len(); i++ {
+		f, err := fieldOf(v.Index(i), field)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := toFloat(f.Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		total += n
+		ints = ints && isInt(f.Interface())
+	}
+
+	if ints {
+		return int(total), nil
+	}
+	return total, nil
+}
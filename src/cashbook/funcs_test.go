@@ -0,0 +1,127 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testGroups() []TxnGroupTotal {
+	var groups []TxnGroupTotal
+	for i, sum := range []int{1, 3, 2, 5, 0, 4, 9, 7} {
+		groups = append(groups, TxnGroupTotal{TxnGroup: TxnGroup{Label: string(rune('a' + i))}, Sum: sum})
+	}
+	return groups
+}
+
+func labels(list interface{}) string {
+	var b strings.Builder
+	for _, g := range list.([]TxnGroupTotal) {
+		b.WriteString(g.Label)
+	}
+	return b.String()
+}
+
+func TestSortByValueStructs(t *testing.T) {
+
+	groups := testGroups()
+
+	sorted, err := sortBy("Sum", groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := labels(sorted); got != "eacbfdhg" {
+		t.Errorf("sortBy Sum = %s, want eacbfdhg", got)
+	}
+
+	sorted, err = sortByDesc("Sum", groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := labels(sorted); got != "ghdfbcae" {
+		t.Errorf("sortByDesc Sum = %s, want ghdfbcae", got)
+	}
+
+	sorted, err = sortByDesc("Label", groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := labels(sorted); got != "hgfedcba" {
+		t.Errorf("sortByDesc Label = %s, want hgfedcba", got)
+	}
+
+	if got := labels(groups); got != "abcdefgh" {
+		t.Errorf("sorting changed the list to %s", got)
+	}
+}
+
+func TestSortByStable(t *testing.T) {
+
+	groups := []TxnGroupTotal{
+		{TxnGroup: TxnGroup{Label: "a"}, Sum: 2},
+		{TxnGroup: TxnGroup{Label: "b"}, Sum: 1},
+		{TxnGroup: TxnGroup{Label: "c"}, Sum: 2},
+		{TxnGroup: TxnGroup{Label: "d"}, Sum: 1},
+	}
+
+	sorted, err := sortBy("Sum", groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := labels(sorted); got != "bdac" {
+		t.Errorf("sortBy Sum = %s, want bdac", got)
+	}
+}
+
+func TestReverseValueStructs(t *testing.T) {
+
+	groups := testGroups()
+
+	reversed, err := reverse(groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := labels(reversed); got != "hgfedcba" {
+		t.Errorf("reverse = %s, want hgfedcba", got)
+	}
+	if got := labels(groups); got != "abcdefgh" {
+		t.Errorf("reverse changed the list to %s", got)
+	}
+}
+
+func TestReverseNils(t *testing.T) {
+
+	reversed, err := reverse([]interface{}{"a", nil, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := reversed.([]interface{})
+	if len(list) != 3 || list[0] != 3 || list[1] != nil || list[2] != "a" {
+		t.Errorf("reverse = %v, want [3 <nil> a]", list)
+	}
+}
+
+func TestSortByUnknownField(t *testing.T) {
+	if _, err := sortBy("Nope", testGroups()); err == nil {
+		t.Error("sortBy an unknown field should fail")
+	}
+}
@@ -0,0 +1,338 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coopernurse/gorp"
+)
+
+// A single stream of records to import: a plain file, a decompressed file
+// or one entry of an archive.
+type ImportInput struct {
+	Name string // Used in logs and checkpoints, archive entries are "archive.zip:entry"
+	Hash string // sha256 of the (decompressed) contents
+
+	open    func() (io.ReadCloser, error)
+	control func() (io.ReadCloser, error) // The .ctl sidecar, nil if there isn't one
+}
+
+// Expand the import arguments into the list of files to read.  Globs are
+// expanded, a directory contributes the files directly inside it and "-"
+// stands for standard input.  Control total sidecars are left out, they're
+// found alongside the file they belong to.
+func expand_import_paths(args []string) ([]string, error) {
+
+	var paths []string
+
+	for _, arg := range args {
+		if arg == "-" {
+			paths = append(paths, arg)
+			continue
+		}
+
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("bad file pattern %q: %v", arg, err)
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match '%s'", arg)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+
+			if !info.IsDir() {
+				if !strings.HasSuffix(match, controlSuffix) {
+					paths = append(paths, match)
+				}
+				continue
+			}
+
+			entries, err := ioutil.ReadDir(match) // Sorted by name, so daily files go in order
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && !strings.HasSuffix(entry.Name(), controlSuffix) {
+					paths = append(paths, filepath.Join(match, entry.Name()))
+				}
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// Return the inputs contained in the file at path, decompressing gzip and
+// bzip2 files and expanding zip archives (detected by their contents, not
+// their name).  Standard input is spooled to a temporary file first so it
+// can be hashed.  The returned cleanup func must be called when done.
+func open_import_inputs(path string) ([]*ImportInput, func(), error) {
+
+	cleanup := func() {}
+
+	name := path
+
+	if path == "-" {
+		spool, err := spool_stdin()
+		if err != nil {
+			return nil, cleanup, err
+		}
+
+		name = "stdin"
+		path = spool
+		cleanup = func() { os.Remove(spool) }
+	}
+
+	magic, err := read_magic(path)
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	var inputs []*ImportInput
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, cleanup, err
+		}
+
+		removeSpool := cleanup
+		cleanup = func() {
+			archive.Close()
+			removeSpool()
+		}
+
+		controls := map[string]*zip.File{}
+		for _, f := range archive.File {
+			if strings.HasSuffix(f.Name, controlSuffix) {
+				controls[strings.TrimSuffix(f.Name, controlSuffix)] = f
+			}
+		}
+
+		for _, f := range archive.File {
+			if f.FileInfo().IsDir() || strings.HasPrefix(filepath.Base(f.Name), ".") || strings.HasSuffix(f.Name, controlSuffix) {
+				continue
+			}
+
+			input := &ImportInput{Name: name + ":" + f.Name, open: f.Open}
+			if ctl, ok := controls[f.Name]; ok {
+				input.control = ctl.Open
+			}
+
+			inputs = append(inputs, input)
+		}
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		inputs = append(inputs, &ImportInput{Name: name, open: func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+
+			return &stackedReader{gz, []io.Closer{gz, file}}, nil
+		}})
+
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		inputs = append(inputs, &ImportInput{Name: name, open: func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+
+			return &stackedReader{bzip2.NewReader(file), []io.Closer{file}}, nil
+		}})
+
+	default:
+		// Plain files are returned as is, the importer can seek in them.
+		inputs = append(inputs, &ImportInput{Name: name, open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		}})
+	}
+
+	// A sidecar next to the file only makes sense when it holds a single input
+	if len(inputs) == 1 && inputs[0].control == nil && name != "stdin" {
+		if ctl := find_control_file(path); ctl != "" {
+			inputs[0].control = func() (io.ReadCloser, error) { return os.Open(ctl) }
+		}
+	}
+
+	for _, input := range inputs {
+		if input.Hash, err = hash_input(input); err != nil {
+			cleanup()
+			return nil, func() {}, fmt.Errorf("reading '%s': %v", input.Name, err)
+		}
+	}
+
+	return inputs, cleanup, nil
+}
+
+// Import a single input, checkpointing against its hash.  When control totals
+// are being checked the input is read through once first, so a mismatch can
+// be rejected before anything is committed, and what was imported is checked
+// against them again at the end.
+func import_input(input *ImportInput, opts ImportOptions, dbm *gorp.DbMap) (*ImportResult, error) {
+
+	if opts.Control != "" {
+		expected, diffs, err := check_control_totals(input, opts.Control, opts.Location)
+		if err != nil {
+			return &ImportResult{}, fmt.Errorf("checking control totals: %v", err)
+		}
+
+		opts.ControlTotals = expected
+
+		if len(diffs) == 0 {
+			opts.ControlStatus = "matched"
+		} else {
+			opts.ControlStatus = "mismatched"
+			opts.ControlMessage = strings.Join(diffs, "; ")
+
+			log.Printf("%s: control totals do not match: %s", input.Name, opts.ControlMessage)
+
+			if opts.RejectMismatch {
+				return &ImportResult{ControlStatus: opts.ControlStatus}, fmt.Errorf("control totals do not match, nothing imported")
+			}
+		}
+	}
+
+	r, err := input.open()
+	if err != nil {
+		return &ImportResult{}, err
+	}
+	defer r.Close()
+
+	opts.FileHash = input.Hash
+	opts.FileName = input.Name
+
+	return importCsv(r, &opts, dbm)
+}
+
+func hash_input(input *ImportInput) (string, error) {
+
+	r, err := input.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Return the control sidecar for path (extract.tsv.gz.ctl or extract.ctl), if any
+func find_control_file(path string) string {
+
+	candidates := []string{path + controlSuffix}
+
+	base := path
+	for ext := filepath.Ext(base); ext != ""; ext = filepath.Ext(base) {
+		base = strings.TrimSuffix(base, ext)
+		candidates = append(candidates, base+controlSuffix)
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// Copy standard input to a temporary file, returning its name
+func spool_stdin() (string, error) {
+
+	spool, err := ioutil.TempFile("", "cashbook-stdin-")
+	if err != nil {
+		return "", err
+	}
+	defer spool.Close()
+
+	if _, err := io.Copy(spool, os.Stdin); err != nil {
+		os.Remove(spool.Name())
+		return "", err
+	}
+
+	return spool.Name(), nil
+}
+
+// Return the first few bytes of the file, enough to tell what format it is
+func read_magic(path string) ([]byte, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	return magic[:n], nil
+}
+
+// A decompressing reader that closes everything beneath it
+type stackedReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (s *stackedReader) Close() error {
+	var first error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,207 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How amounts, percentages and dates are written for members in a locale
+type Locale struct {
+	Name string
+
+	Decimal   string
+	Thousands string
+
+	CurrencyPrefix string // "$" in $1.00
+	CurrencySuffix string // " $" in 1,00 $
+	PercentSuffix  string
+
+	// Names used in place of the English ones when formatting dates
+	Months      []string
+	ShortMonths []string
+	Days        []string
+	ShortDays   []string
+
+	// Layouts for the named date formats: short, medium, long and month
+	DateFormats map[string]string
+}
+
+var englishDateFormats = map[string]string{
+	"short":  "2006-01-02",
+	"medium": "Jan 2, 2006",
+	"long":   "January 2, 2006",
+	"month":  "January 2006",
+}
+
+var englishMonths = []string{"January", "February", "March", "April", "May", "June", "July",
+	"August", "September", "October", "November", "December"}
+var englishShortMonths = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+var englishDays = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+var englishShortDays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+var locales = map[string]*Locale{
+	"en-CA": {
+		Name:           "en-CA",
+		Decimal:        ".",
+		Thousands:      ",",
+		CurrencyPrefix: "$",
+		PercentSuffix:  "%",
+		DateFormats:    englishDateFormats,
+	},
+	"en-US": {
+		Name:           "en-US",
+		Decimal:        ".",
+		Thousands:      ",",
+		CurrencyPrefix: "$",
+		PercentSuffix:  "%",
+		DateFormats:    englishDateFormats,
+	},
+	"fr-CA": {
+		Name:           "fr-CA",
+		Decimal:        ",",
+		Thousands:      "\u00a0", // No-break space, so amounts don't wrap
+		CurrencySuffix: "\u00a0$",
+		PercentSuffix:  "\u00a0%",
+		Months: []string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet",
+			"août", "septembre", "octobre", "novembre", "décembre"},
+		ShortMonths: []string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.",
+			"août", "sept.", "oct.", "nov.", "déc."},
+		Days:      []string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		ShortDays: []string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+		DateFormats: map[string]string{
+			"short":  "2006-01-02",
+			"medium": "2 Jan 2006",
+			"long":   "2 January 2006",
+			"month":  "January 2006",
+		},
+	},
+}
+
+const defaultLocale = "en-CA"
+
+// Return the named locale
+func findLocale(name string) (*Locale, error) {
+
+	if locale, ok := locales[name]; ok {
+		return locale, nil
+	}
+
+	var names []string
+	for name := range locales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return nil, fmt.Errorf("unknown locale %q (expected one of %s)", name, strings.Join(names, ", "))
+}
+
+// Group the digits of a whole number in thousands
+func (l *Locale) group(digits string) string {
+
+	if len(digits) <= 3 {
+		return digits
+	}
+
+	var b strings.Builder
+
+	lead := len(digits) % 3
+	if lead > 0 {
+		b.WriteString(digits[:lead])
+	}
+
+	for i := lead; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(l.Thousands)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+
+	return b.String()
+}
+
+// Format pennies as currency, -$1,234.56 or -1 234,56 $
+func (l *Locale) Currency(pennies int64) string {
+
+	sign := ""
+	if pennies < 0 {
+		sign = "-"
+		pennies = -pennies
+	}
+
+	return fmt.Sprintf("%s%s%s%s%02d%s", sign, l.CurrencyPrefix, l.group(strconv.FormatInt(pennies/100, 10)),
+		l.Decimal, pennies%100, l.CurrencySuffix)
+}
+
+// Format a number with the given number of decimals and thousands grouped
+func (l *Locale) Number(value float64, decimals int) string {
+
+	s := strconv.FormatFloat(value, 'f', decimals, 64)
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+		if strings.Trim(s, "0.") == "" {
+			sign = "" // Don't show -0
+		}
+	}
+
+	whole, fraction := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, fraction = s[:i], l.Decimal+s[i+1:]
+	}
+
+	return sign + l.group(whole) + fraction
+}
+
+// Format a percentage (12.5 for 12.5%) with the given number of decimals
+func (l *Locale) Percent(value float64, decimals int) string {
+	return l.Number(value, decimals) + l.PercentSuffix
+}
+
+// Format a time using a named format (short, medium, long or month) or a Go
+// layout such as "Mon Jan 2", with month and day names in the locale's
+// language.
+func (l *Locale) Date(t time.Time, format string) string {
+
+	layout, ok := l.DateFormats[format]
+	if !ok {
+		layout = format
+	}
+
+	s := t.Format(layout)
+
+	// Full names first, so May doesn't get the short treatment
+	for _, names := range [][2][]string{{englishMonths, l.Months}, {englishDays, l.Days},
+		{englishShortMonths, l.ShortMonths}, {englishShortDays, l.ShortDays}} {
+		if names[1] == nil {
+			continue
+		}
+		for i, english := range names[0] {
+			s = strings.Replace(s, english, names[1][i], -1)
+		}
+	}
+
+	return s
+}
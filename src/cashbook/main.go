@@ -44,17 +44,43 @@ var flagPath = flag.String("path", "conf", "folder containing config files")
 var flagEnv = flag.String("env", "development", "which DB environment to use")
 var flagMatchers = flag.String("matchers", "conf/matchers.json", "file containing the matcher defs")
 var flagPgSchema = flag.String("pgschema", "", "which postgres-schema to migrate (default = none)")
+var flagTimezone = flag.String("timezone", "UTC", "the FI's timezone (IANA name) used for dates and report periods")
 
 // helper to create a DBConf from the given flags
 func dbConfFromFlags() (dbconf *goose.DBConf, err error) {
 	return goose.NewDBConf(*flagPath, *flagEnv, *flagPgSchema)
 }
 
+// Load the named timezone, falling back to the FI-level -timezone option when
+// name is empty.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		name = *flagTimezone
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q: %v", name, err)
+	}
+
+	return loc, nil
+}
+
 const Version = "2014.9"
 
 var commands = []*Command{
 	importCsvCmd,
+	watchCmd,
 	reportCmd,
+	categoryAddCmd,
+	categoryListCmd,
+	categoryRemoveCmd,
+	categoryAssignCmd,
+	recurringCmd,
+	forecastCmd,
+	anomalyCmd,
+	summariesCmd,
+	exportCmd,
 	upCmd,
 	downCmd,
 	redoCmd,
@@ -121,6 +147,11 @@ func initDb() *gorp.DbMap {
 	// specifying that the Id property is an auto incrementing PK
 	dbmap.AddTableWithName(Txn{}, "txns").SetKeys(true, "Id")
 	dbmap.AddTableWithName(TxnGroup{}, "txn_groups").SetKeys(true, "Id")
+	dbmap.AddTableWithName(Category{}, "categories").SetKeys(true, "Id")
+	dbmap.AddTableWithName(RecurringSeries{}, "recurring_series").SetKeys(true, "Id")
+	dbmap.AddTableWithName(Anomaly{}, "anomalies").SetKeys(true, "Id")
+	dbmap.AddTableWithName(ImportFile{}, "import_files").SetKeys(true, "Id")
+	dbmap.AddTableWithName(ImportCheckpoint{}, "import_checkpoints").SetKeys(false, "FileHash")
 
 	return dbmap
 }
@@ -0,0 +1,223 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A minimal PDF writer: pages of text, rects and lines in the standard
+// Helvetica fonts, which every PDF reader has, so nothing is embedded.
+// Positions are in points from the top left of the page.
+type pdfDocument struct {
+	Title  string
+	Width  float64
+	Height float64
+
+	pages []*pdfPage
+}
+
+type pdfPage struct {
+	height  float64
+	content bytes.Buffer
+}
+
+// Page sizes in points
+var pdfPageSizes = map[string][2]float64{
+	"letter": {612, 792},
+	"legal":  {612, 1008},
+	"a4":     {595.28, 841.89},
+}
+
+func newPDFDocument(width, height float64) *pdfDocument {
+	return &pdfDocument{Width: width, Height: height}
+}
+
+func (d *pdfDocument) addPage() *pdfPage {
+	page := &pdfPage{height: d.Height}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// An RGB color, components 0 to 1
+type pdfColor struct {
+	R, G, B float64
+}
+
+var pdfBlack = pdfColor{0, 0, 0}
+
+// Draw text with its baseline at y
+func (p *pdfPage) text(x, y float64, s string, font pdfFont, size float64, color pdfColor) {
+	fmt.Fprintf(&p.content, "BT %s rg /F%d %s Tf %s %s Td (%s) Tj ET\n",
+		color.ops(), int(font)+1, pdfNum(size), pdfNum(x), pdfNum(p.height-y), pdfString(s))
+}
+
+// Fill and/or stroke a rect, a nil color being neither
+func (p *pdfPage) rect(x, y, w, h float64, fill *pdfColor, stroke *pdfColor, lineWidth float64) {
+	p.shape(fmt.Sprintf("%s %s %s %s re", pdfNum(x), pdfNum(p.height-y-h), pdfNum(w), pdfNum(h)), fill, stroke, lineWidth)
+}
+
+// Draw connected lines through the points, closing and filling them into a
+// polygon if there's a fill
+func (p *pdfPage) polygon(points []ChartPoint, fill *pdfColor, stroke *pdfColor, lineWidth float64) {
+
+	if len(points) < 2 {
+		return
+	}
+
+	var path strings.Builder
+	for i, point := range points {
+		op := "l"
+		if i == 0 {
+			op = "m"
+		}
+		fmt.Fprintf(&path, "%s %s %s ", pdfNum(point.X), pdfNum(p.height-point.Y), op)
+	}
+
+	if fill != nil {
+		path.WriteString("h")
+	}
+
+	p.shape(path.String(), fill, stroke, lineWidth)
+}
+
+func (p *pdfPage) line(x1, y1, x2, y2 float64, color pdfColor, lineWidth float64) {
+	p.polygon([]ChartPoint{{x1, y1}, {x2, y2}}, nil, &color, lineWidth)
+}
+
+func (p *pdfPage) shape(path string, fill *pdfColor, stroke *pdfColor, lineWidth float64) {
+
+	op := ""
+	switch {
+	case fill != nil && stroke != nil:
+		op = "B"
+	case fill != nil:
+		op = "f"
+	case stroke != nil:
+		op = "S"
+	default:
+		return
+	}
+
+	p.content.WriteString("q ")
+	if fill != nil {
+		fmt.Fprintf(&p.content, "%s rg ", fill.ops())
+	}
+	if stroke != nil {
+		fmt.Fprintf(&p.content, "%s RG %s w ", stroke.ops(), pdfNum(lineWidth))
+	}
+	fmt.Fprintf(&p.content, "%s %s Q\n", path, op)
+}
+
+func (c pdfColor) ops() string {
+	return pdfNum(c.R) + " " + pdfNum(c.G) + " " + pdfNum(c.B)
+}
+
+func pdfNum(f float64) string {
+	return num(f)
+}
+
+// Encode text as a PDF string in WinAnsiEncoding, escaping as needed
+func pdfString(s string) string {
+
+	var b strings.Builder
+
+	for _, c := range winAnsi(s) {
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// Write the document
+func (d *pdfDocument) Write(w io.Writer) error {
+
+	out := bufio.NewWriter(w)
+
+	var offsets []int
+	written := 0
+
+	write := func(format string, args ...interface{}) {
+		n, _ := fmt.Fprintf(out, format, args...)
+		written += n
+	}
+
+	// Objects are numbered from 1 in the order they're written
+	object := func(body string) {
+		offsets = append(offsets, written)
+		write("%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Catalog, page tree, info and fonts first, then each page and its
+	// contents
+	const catalog, pages, info, firstFont = 1, 2, 3, 4
+	firstPage := firstFont + len(pdfFontNames)
+
+	write("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	object(fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages))
+
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+i*2))
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	object(fmt.Sprintf("<< /Title (%s) /Producer (Cashbook) >>", pdfString(d.Title)))
+
+	var fonts []string
+	for i, name := range pdfFontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fonts = append(fonts, fmt.Sprintf("/F%d %d 0 R", i+1, firstFont+i))
+	}
+
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pages, pdfNum(d.Width), pdfNum(d.Height), strings.Join(fonts, " "), firstPage+i*2+1))
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(page.content.Bytes())
+		zw.Close()
+
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String()))
+	}
+
+	xref := written
+	write("xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		write("%010d 00000 n \n", offset)
+	}
+
+	write("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, catalog, info, xref)
+
+	return out.Flush()
+}
@@ -0,0 +1,121 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+// The standard fonts PDFs can use, bold and italic combining
+type pdfFont int
+
+const (
+	pdfRegular pdfFont = 0
+	pdfBold    pdfFont = 1
+	pdfItalic  pdfFont = 2
+)
+
+// In pdfFont order, F1 to F4 in the document
+var pdfFontNames = []string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique", "Helvetica-BoldOblique"}
+
+// Character widths (in 1000ths of the font size) of Helvetica and
+// Helvetica-Bold for ASCII 32 to 126, from their AFM metrics.  The oblique
+// fonts are the same widths.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// WinAnsiEncoding is Latin-1 apart from these, in 0x80 to 0x9f
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// Encode text in WinAnsiEncoding, characters it doesn't have becoming ?
+func winAnsi(s string) []byte {
+
+	b := make([]byte, 0, len(s))
+
+	for _, c := range s {
+		switch special, ok := winAnsiSpecials[c]; {
+		case ok:
+			b = append(b, special)
+		case c < 0x80 || c >= 0xa0 && c <= 0xff:
+			b = append(b, byte(c))
+		default:
+			b = append(b, '?')
+		}
+	}
+
+	return b
+}
+
+// The ASCII characters accented Latin-1 letters are as wide as
+var latin1Bases = map[byte]byte{
+	0xc0: 'A', 0xc1: 'A', 0xc2: 'A', 0xc3: 'A', 0xc4: 'A', 0xc5: 'A', 0xc7: 'C',
+	0xc8: 'E', 0xc9: 'E', 0xca: 'E', 0xcb: 'E', 0xcc: 'I', 0xcd: 'I', 0xce: 'I', 0xcf: 'I',
+	0xd1: 'N', 0xd2: 'O', 0xd3: 'O', 0xd4: 'O', 0xd5: 'O', 0xd6: 'O', 0xd9: 'U', 0xda: 'U',
+	0xdb: 'U', 0xdc: 'U', 0xdd: 'Y',
+	0xe0: 'a', 0xe1: 'a', 0xe2: 'a', 0xe3: 'a', 0xe4: 'a', 0xe5: 'a', 0xe7: 'c',
+	0xe8: 'e', 0xe9: 'e', 0xea: 'e', 0xeb: 'e', 0xec: 'i', 0xed: 'i', 0xee: 'i', 0xef: 'i',
+	0xf1: 'n', 0xf2: 'o', 0xf3: 'o', 0xf4: 'o', 0xf5: 'o', 0xf6: 'o', 0xf9: 'u', 0xfa: 'u',
+	0xfb: 'u', 0xfc: 'u', 0xfd: 'y', 0xff: 'y',
+	0xa0: ' ', 0x91: '\'', 0x92: '\'',
+}
+
+// Return the width of text in points
+func textWidth(s string, font pdfFont, size float64) float64 {
+
+	widths := &helveticaWidths
+	if font&pdfBold != 0 {
+		widths = &helveticaBoldWidths
+	}
+
+	total := 0
+	for _, c := range winAnsi(s) {
+		if base, ok := latin1Bases[c]; ok {
+			c = base
+		}
+		switch {
+		case c == 0x97:
+			total += 1000 // Em dash
+		case c == 0x95:
+			total += 350 // Bullet
+		case c >= 32 && c <= 126:
+			total += widths[c-32]
+		default:
+			total += 556
+		}
+	}
+
+	return float64(total) * size / 1000
+}
@@ -0,0 +1,747 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Reports are rendered to PDF by laying out the subset of HTML statements
+// use: headings, paragraphs, lists, tables, inline text styles and the SVG
+// charts of the chart functions.  Only inline style attributes are applied
+// (text-align, font-weight, font-style, font-size, color, background-color,
+// width: 100% on tables and page-break-before/after), <style> is ignored.
+
+// How far in the page content starts, in points
+const pdfMargin = 50
+
+const pdfBaseFontSize = 10
+
+// Points in a CSS pixel
+const pdfPixel = 0.75
+
+// Render HTML as a PDF with pages of the given size (letter, legal or a4)
+func htmlToPDF(r io.Reader, w io.Writer, pageSize string) error {
+
+	size, ok := pdfPageSizes[pageSize]
+	if !ok {
+		size = pdfPageSizes["letter"]
+	}
+
+	root, err := parseHTML(r)
+	if err != nil {
+		return err
+	}
+
+	doc := newPDFDocument(size[0], size[1])
+	doc.Title = strings.TrimSpace(root.find("title").text())
+
+	l := &pdfLayout{doc: doc}
+	l.newPage()
+
+	l.layoutBlock(root, pdfMargin, doc.Width-2*pdfMargin, pdfStyle{Size: pdfBaseFontSize, Color: pdfBlack})
+
+	return doc.Write(w)
+}
+
+// An element (or text) of an HTML document
+type htmlNode struct {
+	Tag      string // Lower case, empty for text
+	Attrs    map[string]string
+	Text     string
+	Children []*htmlNode
+}
+
+// Script and style elements hold raw text, which needn't be valid XML
+var htmlRawText = []*regexp.Regexp{
+	regexp.MustCompile(`(?is)(<script\b[^>]*>).*?(</script\s*>)`),
+	regexp.MustCompile(`(?is)(<style\b[^>]*>).*?(</style\s*>)`),
+}
+
+// Parse HTML leniently: void elements needn't be closed and unclosed
+// elements are closed by their parent's end tag.  Otherwise the document
+// must be well-formed XHTML: a bare < in text is an error.
+func parseHTML(r io.Reader) (*htmlNode, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// They aren't laid out, so drop their text rather than parse it
+	for _, re := range htmlRawText {
+		b = re.ReplaceAll(b, []byte("$1$2"))
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	root := &htmlNode{Tag: "#document"}
+	stack := []*htmlNode{root}
+
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		top := stack[len(stack)-1]
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &htmlNode{Tag: strings.ToLower(t.Name.Local), Attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				n.Attrs[strings.ToLower(a.Name.Local)] = a.Value
+			}
+			top.Children = append(top.Children, n)
+			stack = append(stack, n)
+
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].Tag == name {
+					stack = stack[:i]
+					break
+				}
+			}
+
+		case xml.CharData:
+			top.Children = append(top.Children, &htmlNode{Text: string(t)})
+		}
+	}
+
+	return root, nil
+}
+
+// Return the first element with the tag, an empty node if there isn't one
+func (n *htmlNode) find(tag string) *htmlNode {
+
+	if n.Tag == tag {
+		return n
+	}
+
+	for _, c := range n.Children {
+		if found := c.find(tag); found.Tag != "" {
+			return found
+		}
+	}
+
+	return &htmlNode{}
+}
+
+// Return the text within a node
+func (n *htmlNode) text() string {
+
+	if n.Tag == "" {
+		return n.Text
+	}
+
+	var b strings.Builder
+	for _, c := range n.Children {
+		b.WriteString(c.text())
+	}
+
+	return b.String()
+}
+
+// Elements laid out as blocks, everything else flows as text
+var htmlBlocks = map[string]bool{
+	"#document": true, "html": true, "body": true, "div": true, "p": true, "section": true, "article": true,
+	"header": true, "footer": true, "main": true, "nav": true, "aside": true, "center": true, "address": true,
+	"blockquote": true, "pre": true, "figure": true, "figcaption": true, "form": true, "fieldset": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true,
+	"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+	"table": true, "caption": true, "thead": true, "tbody": true, "tfoot": true, "tr": true, "td": true, "th": true,
+	"svg": true,
+}
+
+// Elements that aren't content
+var htmlSkipped = map[string]bool{"head": true, "title": true, "style": true, "script": true, "meta": true, "link": true, "template": true}
+
+// Heading font sizes and the space around them, in ems
+var htmlHeadings = map[string]float64{"h1": 2, "h2": 1.6, "h3": 1.3, "h4": 1.1, "h5": 1, "h6": 0.9}
+
+// The text style of an element, inherited by its children apart from
+// Background
+type pdfStyle struct {
+	Size       float64
+	Font       pdfFont
+	Color      pdfColor
+	Align      string // left, center or right
+	Background *pdfColor
+}
+
+// Work out an element's style from its tag, attributes and style attribute
+func (n *htmlNode) style(parent pdfStyle) pdfStyle {
+
+	s := parent
+	s.Background = nil
+
+	switch n.Tag {
+	case "b", "strong", "th", "dt":
+		s.Font |= pdfBold
+	case "i", "em", "cite":
+		s.Font |= pdfItalic
+	case "small":
+		s.Size *= 0.85
+	case "center", "caption":
+		s.Align = "center"
+	}
+
+	if em, ok := htmlHeadings[n.Tag]; ok {
+		s.Size = pdfBaseFontSize * em
+		s.Font |= pdfBold
+	}
+
+	if align := n.Attrs["align"]; align != "" {
+		s.Align = strings.ToLower(align)
+	}
+	if color, ok := parseColor(n.Attrs["bgcolor"]); ok {
+		s.Background = &color
+	}
+
+	css := n.css()
+
+	if align := css["text-align"]; align != "" {
+		s.Align = align
+	}
+
+	switch css["font-weight"] {
+	case "bold", "bolder", "600", "700", "800", "900":
+		s.Font |= pdfBold
+	case "normal", "lighter", "100", "200", "300", "400":
+		s.Font &^= pdfBold
+	}
+
+	switch css["font-style"] {
+	case "italic", "oblique":
+		s.Font |= pdfItalic
+	case "normal":
+		s.Font &^= pdfItalic
+	}
+
+	if size, ok := cssLength(css["font-size"], parent.Size); ok && size > 0 {
+		s.Size = size
+	}
+
+	if color, ok := parseColor(css["color"]); ok {
+		s.Color = color
+	}
+
+	for _, property := range []string{"background-color", "background"} {
+		if color, ok := parseColor(css[property]); ok {
+			s.Background = &color
+		}
+	}
+
+	return s
+}
+
+// Return an element's style attribute as a map of properties
+func (n *htmlNode) css() map[string]string {
+
+	properties := map[string]string{}
+
+	for _, declaration := range strings.Split(n.Attrs["style"], ";") {
+		pieces := strings.SplitN(declaration, ":", 2)
+		if len(pieces) == 2 {
+			value := strings.TrimSpace(strings.Replace(pieces[1], "!important", "", -1))
+			properties[strings.ToLower(strings.TrimSpace(pieces[0]))] = strings.ToLower(value)
+		}
+	}
+
+	return properties
+}
+
+// Convert a CSS length (12pt, 16px, 1.2em, 120%) to points
+func cssLength(value string, em float64) (float64, bool) {
+
+	units := map[string]float64{"pt": 1, "px": pdfPixel, "em": em, "rem": pdfBaseFontSize, "%": em / 100, "in": 72, "cm": 72 / 2.54, "mm": 72 / 25.4}
+
+	for unit, scale := range units {
+		if strings.HasSuffix(value, unit) {
+			if f, err := strconv.ParseFloat(strings.TrimSuffix(value, unit), 64); err == nil {
+				return f * scale, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+var namedColors = map[string]pdfColor{
+	"black": {0, 0, 0}, "white": {1, 1, 1}, "gray": {0.5, 0.5, 0.5}, "grey": {0.5, 0.5, 0.5},
+	"silver": {0.75, 0.75, 0.75}, "lightgray": {0.83, 0.83, 0.83}, "lightgrey": {0.83, 0.83, 0.83},
+	"red": {1, 0, 0}, "maroon": {0.5, 0, 0}, "green": {0, 0.5, 0}, "lime": {0, 1, 0}, "navy": {0, 0, 0.5},
+	"blue": {0, 0, 1}, "teal": {0, 0.5, 0.5}, "orange": {1, 0.65, 0}, "purple": {0.5, 0, 0.5},
+	"yellow": {1, 1, 0}, "darkgreen": {0, 0.39, 0}, "darkred": {0.55, 0, 0},
+}
+
+// Parse a CSS color: #rgb, #rrggbb, rgb(r, g, b) or a common name
+func parseColor(value string) (pdfColor, bool) {
+
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	if color, ok := namedColors[value]; ok {
+		return color, true
+	}
+
+	if strings.HasPrefix(value, "#") {
+		hex := value[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) == 6 {
+			if rgb, err := strconv.ParseUint(hex, 16, 32); err == nil {
+				return pdfColor{float64(rgb>>16) / 255, float64(rgb>>8&0xff) / 255, float64(rgb&0xff) / 255}, true
+			}
+		}
+	}
+
+	if strings.HasPrefix(value, "rgb(") && strings.HasSuffix(value, ")") {
+		parts := strings.Split(value[4:len(value)-1], ",")
+		if len(parts) == 3 {
+			var rgb [3]float64
+			for i, part := range parts {
+				f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+				if err != nil {
+					return pdfColor{}, false
+				}
+				rgb[i] = math.Max(0, math.Min(255, f)) / 255
+			}
+			return pdfColor{rgb[0], rgb[1], rgb[2]}, true
+		}
+	}
+
+	return pdfColor{}, false
+}
+
+// Lays out a document down pages
+type pdfLayout struct {
+	doc  *pdfDocument
+	page *pdfPage
+	y    float64 // Where the next block goes, from the top of the page
+}
+
+func (l *pdfLayout) newPage() {
+	l.page = l.doc.addPage()
+	l.y = pdfMargin
+}
+
+func (l *pdfLayout) bottom() float64 {
+	return l.doc.Height - pdfMargin
+}
+
+// Start a new page if height won't fit on this one, unless it's already
+// empty (the content's taller than a page)
+func (l *pdfLayout) room(height float64) {
+	if l.y+height > l.bottom() && l.y > pdfMargin {
+		l.newPage()
+	}
+}
+
+func (l *pdfLayout) layoutBlock(n *htmlNode, x float64, width float64, parent pdfStyle) {
+
+	if htmlSkipped[n.Tag] {
+		return
+	}
+
+	s := n.style(parent)
+	css := n.css()
+
+	if breaks(css, "before") && l.y > pdfMargin {
+		l.newPage()
+	}
+
+	before, after := 0.0, 0.0
+
+	switch n.Tag {
+	case "p", "ul", "ol", "dl", "pre", "figure":
+		after = s.Size * 0.6
+	case "table":
+		after = s.Size * 0.8
+	case "blockquote":
+		after = s.Size * 0.6
+		x, width = x+20, width-20
+	case "dd":
+		x, width = x+20, width-20
+	}
+
+	if _, ok := htmlHeadings[n.Tag]; ok {
+		before, after = s.Size*0.5, s.Size*0.3
+	}
+
+	if l.y > pdfMargin {
+		l.y += before
+	}
+
+	switch n.Tag {
+	case "hr":
+		l.room(s.Size)
+		l.y += s.Size / 2
+		l.page.line(x, l.y, x+width, l.y, pdfColor{0.6, 0.6, 0.6}, 0.75)
+		l.y += s.Size / 2
+
+	case "table":
+		l.layoutTable(n, x, width, s)
+
+	case "svg":
+		l.layoutChart(parseSVGChart(n), x, width, s.Align)
+
+	case "ul", "ol":
+		l.layoutList(n, x, width, s)
+
+	default:
+		l.layoutChildren(n, x, width, s)
+	}
+
+	l.y += after
+
+	if breaks(css, "after") {
+		l.newPage()
+	}
+}
+
+func breaks(css map[string]string, side string) bool {
+	return css["page-break-"+side] == "always" || css["break-"+side] == "page"
+}
+
+// Lay out an element's children, blocks in turn and runs of inline content
+// as paragraphs
+func (l *pdfLayout) layoutChildren(n *htmlNode, x float64, width float64, s pdfStyle) {
+
+	var runs []pdfRun
+
+	flush := func() {
+		if len(runs) > 0 {
+			for _, line := range wrapRuns(runs, width) {
+				l.room(line.Height)
+				line.draw(l.page, x, l.y, width, s.Align)
+				l.y += line.Height
+			}
+			runs = nil
+		}
+	}
+
+	for _, c := range n.Children {
+		if htmlBlocks[c.Tag] {
+			flush()
+			l.layoutBlock(c, x, width, s)
+		} else {
+			runs = collectRuns(c, s, runs)
+		}
+	}
+
+	flush()
+}
+
+func (l *pdfLayout) layoutList(n *htmlNode, x float64, width float64, s pdfStyle) {
+
+	const indent = 18
+
+	number := 0
+
+	for _, item := range n.Children {
+		if item.Tag != "li" {
+			if item.Tag != "" {
+				l.layoutBlock(item, x+indent, width-indent, s)
+			}
+			continue
+		}
+
+		number++
+
+		marker := "•"
+		if n.Tag == "ol" {
+			marker = strconv.Itoa(number) + "."
+		}
+
+		is := item.style(s)
+
+		l.room(is.Size * 1.25)
+		l.page.text(x+indent-6-textWidth(marker, is.Font, is.Size), l.y+is.Size*0.95, marker, is.Font, is.Size, is.Color)
+
+		l.layoutBlock(item, x+indent, width-indent, s)
+	}
+}
+
+// Draw a chart from its shapes, shrunk to fit the width if need be
+func (l *pdfLayout) layoutChart(chart *Chart, x float64, width float64, align string) {
+
+	if chart.Width <= 0 || chart.Height <= 0 {
+		return
+	}
+
+	scale := chartScale(chart, width)
+	height := chart.Height * scale
+
+	switch align {
+	case "center":
+		x += (width - chart.Width*scale) / 2
+	case "right":
+		x += width - chart.Width*scale
+	}
+
+	l.room(height)
+	drawChart(l.page, chart, x, l.y, scale)
+	l.y += height + 4
+}
+
+// A piece of text in one style, or a line break
+type pdfRun struct {
+	Text  string
+	Font  pdfFont
+	Size  float64
+	Color pdfColor
+	Break bool
+}
+
+// Collect the text of inline content, with whitespace collapsed
+func collectRuns(n *htmlNode, s pdfStyle, runs []pdfRun) []pdfRun {
+
+	switch {
+	case n.Tag == "":
+		text := strings.Join(strings.FieldsFunc(n.Text, isHTMLSpace), " ")
+		if text == "" && n.Text != "" {
+			text = " "
+		} else if text != "" {
+			if isHTMLSpace(rune(n.Text[0])) {
+				text = " " + text
+			}
+			if isHTMLSpace(rune(n.Text[len(n.Text)-1])) {
+				text += " "
+			}
+		}
+		if text != "" {
+			runs = append(runs, pdfRun{Text: text, Font: s.Font, Size: s.Size, Color: s.Color})
+		}
+
+	case n.Tag == "br":
+		runs = append(runs, pdfRun{Break: true, Size: s.Size})
+
+	case n.Tag == "img":
+		if alt := n.Attrs["alt"]; alt != "" {
+			runs = append(runs, pdfRun{Text: alt, Font: s.Font, Size: s.Size, Color: s.Color})
+		}
+
+	case htmlSkipped[n.Tag]:
+
+	default:
+		cs := n.style(s)
+		for _, c := range n.Children {
+			runs = collectRuns(c, cs, runs)
+		}
+	}
+
+	return runs
+}
+
+// HTML whitespace, not including no-break spaces
+func isHTMLSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// Text that can't be broken across lines, words and the punctuation or
+// styled text touching them
+type pdfUnit struct {
+	Runs        []pdfRun
+	Width       float64
+	SpaceBefore bool
+	Break       bool
+}
+
+// A laid out line of text
+type pdfLine struct {
+	Units  []pdfUnit
+	Width  float64
+	Height float64
+	Size   float64 // The largest font size
+}
+
+// Split runs into unbreakable units
+func splitRuns(runs []pdfRun) []pdfUnit {
+
+	var units []pdfUnit
+	space := false
+	open := false // The last unit can be added to
+
+	for _, run := range runs {
+		if run.Break {
+			units = append(units, pdfUnit{Break: true, Runs: []pdfRun{run}})
+			space, open = false, false
+			continue
+		}
+
+		for i, word := range strings.Split(run.Text, " ") {
+			if i > 0 {
+				space, open = true, false
+			}
+			if word == "" {
+				continue
+			}
+
+			piece := run
+			piece.Text = word
+			width := textWidth(word, run.Font, run.Size)
+
+			if open {
+				last := &units[len(units)-1]
+				last.Runs = append(last.Runs, piece)
+				last.Width += width
+			} else {
+				units = append(units, pdfUnit{Runs: []pdfRun{piece}, Width: width, SpaceBefore: space})
+			}
+
+			space, open = false, true
+		}
+	}
+
+	return units
+}
+
+func (u *pdfUnit) spaceWidth() float64 {
+	return textWidth(" ", u.Runs[0].Font, u.Runs[0].Size)
+}
+
+// Fill lines of the given width with runs
+func wrapRuns(runs []pdfRun, width float64) []pdfLine {
+
+	var lines []pdfLine
+	line := pdfLine{}
+
+	end := func() {
+		if line.Size == 0 {
+			return
+		}
+		line.Height = line.Size * 1.25
+		lines = append(lines, line)
+		line = pdfLine{}
+	}
+
+	for _, unit := range splitRuns(runs) {
+		if unit.Break {
+			if line.Size == 0 {
+				line.Size = unit.Runs[0].Size // An empty line
+			}
+			end()
+			continue
+		}
+
+		space := 0.0
+		if len(line.Units) > 0 && unit.SpaceBefore {
+			space = unit.spaceWidth()
+		}
+
+		if len(line.Units) > 0 && line.Width+space+unit.Width > width {
+			end()
+			space = 0
+		}
+
+		if len(line.Units) == 0 {
+			unit.SpaceBefore = false
+		}
+
+		line.Units = append(line.Units, unit)
+		line.Width += space + unit.Width
+
+		for _, run := range unit.Runs {
+			line.Size = math.Max(line.Size, run.Size)
+		}
+	}
+
+	end()
+
+	return lines
+}
+
+// Return the width of runs on one line, and of their widest unit
+func measureRuns(runs []pdfRun) (float64, float64) {
+
+	widest, natural := 0.0, 0.0
+
+	for _, line := range wrapRuns(runs, math.Inf(1)) {
+		natural = math.Max(natural, line.Width)
+		for _, unit := range line.Units {
+			widest = math.Max(widest, unit.Width)
+		}
+	}
+
+	return natural, widest
+}
+
+// Draw a line with its top at y
+func (line *pdfLine) draw(page *pdfPage, x float64, y float64, width float64, align string) {
+
+	switch align {
+	case "right":
+		x += width - line.Width
+	case "center":
+		x += (width - line.Width) / 2
+	}
+
+	baseline := y + line.Size*0.95
+
+	// Text in the same style is drawn together, spaces and all, so it
+	// copies out of the PDF as it was
+	var pending *pdfRun
+	start := x
+
+	draw := func() {
+		if pending != nil {
+			page.text(start, baseline, pending.Text, pending.Font, pending.Size, pending.Color)
+			pending = nil
+		}
+	}
+
+	for _, unit := range line.Units {
+		for i, run := range unit.Runs {
+			space := 0.0
+			if i == 0 && unit.SpaceBefore {
+				space = unit.spaceWidth()
+			}
+
+			if pending != nil && pending.Font == run.Font && pending.Size == run.Size && pending.Color == run.Color {
+				if space > 0 {
+					pending.Text += " "
+				}
+				pending.Text += run.Text
+			} else {
+				draw()
+				start = x + space
+				pending = &pdfRun{Text: run.Text, Font: run.Font, Size: run.Size, Color: run.Color}
+			}
+
+			x += space + textWidth(run.Text, run.Font, run.Size)
+		}
+	}
+
+	draw()
+}
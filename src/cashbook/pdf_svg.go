@@ -0,0 +1,197 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Inline SVG charts in PDFs: the shapes the chart functions draw are read
+// back into a Chart and drawn with PDF operators.
+
+// Read the shapes of an inline SVG back into a chart, so the charts drawn
+// by the chart functions appear in PDFs.  Rects, polygons, polylines, lines
+// and text are understood, in groups or not.
+func parseSVGChart(n *htmlNode) *Chart {
+
+	chart := &Chart{Width: svgLength(n.Attrs["width"]), Height: svgLength(n.Attrs["height"])}
+
+	if box := strings.Fields(strings.Replace(n.Attrs["viewbox"], ",", " ", -1)); len(box) == 4 && (chart.Width == 0 || chart.Height == 0) {
+		chart.Width, chart.Height = svgLength(box[2]), svgLength(box[3])
+	}
+
+	addSVGShapes(chart, n, n.Attrs)
+
+	return chart
+}
+
+// Add the shapes within an element, attrs being those groups pass on
+func addSVGShapes(chart *Chart, n *htmlNode, inherited map[string]string) {
+
+	for _, c := range n.Children {
+		attr := func(name string) string {
+			if value, ok := c.Attrs[name]; ok {
+				return value
+			}
+			return inherited[name]
+		}
+		length := func(name string) float64 {
+			return svgLength(c.Attrs[name])
+		}
+
+		switch c.Tag {
+		case "g", "a":
+			attrs := map[string]string{}
+			for k, v := range inherited {
+				attrs[k] = v
+			}
+			for k, v := range c.Attrs {
+				attrs[k] = v
+			}
+			addSVGShapes(chart, c, attrs)
+
+		case "rect":
+			chart.Shapes = append(chart.Shapes, ChartShape{Kind: "rect", X: length("x"), Y: length("y"),
+				W: length("width"), H: length("height"), Fill: svgFill(attr("fill")), Stroke: attr("stroke"),
+				StrokeWidth: svgStrokeWidth(attr("stroke-width"))})
+
+		case "polygon", "polyline":
+			shape := ChartShape{Kind: c.Tag, Points: svgPointList(c.Attrs["points"]), Fill: svgFill(attr("fill")),
+				Stroke: attr("stroke"), StrokeWidth: svgStrokeWidth(attr("stroke-width"))}
+			if c.Tag == "polyline" {
+				shape.Fill = ""
+			}
+			chart.Shapes = append(chart.Shapes, shape)
+
+		case "line":
+			chart.Shapes = append(chart.Shapes, ChartShape{Kind: "polyline",
+				Points: []ChartPoint{{length("x1"), length("y1")}, {length("x2"), length("y2")}},
+				Stroke: attr("stroke"), StrokeWidth: svgStrokeWidth(attr("stroke-width"))})
+
+		case "text":
+			size := svgLength(attr("font-size"))
+			if size == 0 {
+				size = 16
+			}
+			weight := attr("font-weight")
+			chart.Shapes = append(chart.Shapes, ChartShape{Kind: "text", X: length("x"), Y: length("y"),
+				Text: strings.TrimSpace(c.text()), FontSize: size, Anchor: attr("text-anchor"),
+				Fill: svgFill(attr("fill")), Bold: weight == "bold" || weight == "700"})
+		}
+	}
+}
+
+// Shapes are black unless they say otherwise
+func svgFill(fill string) string {
+	if fill == "" {
+		return "black"
+	}
+	return fill
+}
+
+func svgStrokeWidth(width string) float64 {
+	if width == "" {
+		return 1
+	}
+	return svgLength(width)
+}
+
+func svgLength(value string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "px"), 64)
+	return f
+}
+
+func svgPointList(points string) []ChartPoint {
+
+	fields := strings.Fields(strings.Replace(points, ",", " ", -1))
+
+	var list []ChartPoint
+	for i := 0; i+1 < len(fields); i += 2 {
+		list = append(list, ChartPoint{svgLength(fields[i]), svgLength(fields[i+1])})
+	}
+
+	return list
+}
+
+// The scale a chart is drawn at: its size in pixels, shrunk to fit the
+// width if need be
+func chartScale(chart *Chart, width float64) float64 {
+	if chart.Width <= 0 {
+		return pdfPixel
+	}
+	return math.Min(pdfPixel, width/chart.Width)
+}
+
+// Draw a chart's shapes with its top left at x, y
+func drawChart(page *pdfPage, chart *Chart, x float64, y float64, scale float64) {
+
+	paint := func(color string) *pdfColor {
+		if c, ok := parseColor(color); ok {
+			return &c
+		}
+		return nil
+	}
+
+	points := func(list []ChartPoint) []ChartPoint {
+		moved := make([]ChartPoint, len(list))
+		for i, p := range list {
+			moved[i] = ChartPoint{x + p.X*scale, y + p.Y*scale}
+		}
+		return moved
+	}
+
+	for _, s := range chart.Shapes {
+		switch s.Kind {
+		case "rect":
+			page.rect(x+s.X*scale, y+s.Y*scale, s.W*scale, s.H*scale, paint(s.Fill), paint(s.Stroke), s.StrokeWidth*scale)
+
+		case "polygon":
+			page.polygon(points(s.Points), paint(s.Fill), paint(s.Stroke), s.StrokeWidth*scale)
+
+		case "polyline":
+			page.polygon(points(s.Points), nil, paint(s.Stroke), s.StrokeWidth*scale)
+
+		case "text":
+			fill := paint(s.Fill)
+			if fill == nil {
+				continue
+			}
+
+			font := pdfRegular
+			if s.Bold {
+				font = pdfBold
+			}
+
+			size := s.FontSize * scale
+			left := x + s.X*scale
+
+			switch s.Anchor {
+			case "middle":
+				left -= textWidth(s.Text, font, size) / 2
+			case "end":
+				left -= textWidth(s.Text, font, size)
+			}
+
+			page.text(left, y+s.Y*scale, s.Text, font, size, *fill)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// Space between a table cell's edges and its content, in points
+const cellPaddingX, cellPaddingY = 4, 3
+
+var pdfBorderColor = pdfColor{0.6, 0.6, 0.6}
+
+type pdfTableRow struct {
+	Cells      []*pdfTableCell
+	Header     bool
+	Background *pdfColor
+
+	allHeadings bool // Every cell is a th
+}
+
+type pdfTableCell struct {
+	Span   int
+	Style  pdfStyle
+	Blocks []pdfCellBlock
+
+	// Laid out
+	x, width float64
+	lines    [][]pdfLine
+	height   float64
+}
+
+// A paragraph or a chart within a cell
+type pdfCellBlock struct {
+	Runs  []pdfRun
+	Chart *Chart
+	Align string
+}
+
+func (l *pdfLayout) layoutTable(n *htmlNode, x float64, width float64, s pdfStyle) {
+
+	rows := tableRows(n, s)
+
+	for _, c := range n.Children {
+		if c.Tag == "caption" {
+			l.layoutBlock(c, x, width, s)
+		}
+	}
+
+	if len(rows) == 0 {
+		return
+	}
+
+	widths := columnWidths(rows, width, n.Attrs["width"] == "100%" || n.css()["width"] == "100%")
+
+	tableWidth := 0.0
+	for _, w := range widths {
+		tableWidth += w
+	}
+
+	switch n.Attrs["align"] {
+	case "center":
+		x += (width - tableWidth) / 2
+	case "right":
+		x += width - tableWidth
+	}
+
+	border := n.Attrs["border"] != "" && n.Attrs["border"] != "0" || n.css()["border"] != "" && n.css()["border"] != "none"
+
+	var headers []*pdfTableRow
+	for _, row := range rows {
+		if !row.Header {
+			break
+		}
+		headers = append(headers, row)
+	}
+
+	for i, row := range rows {
+		height := row.layout(x, widths)
+
+		if l.y+height > l.bottom() && l.y > pdfMargin {
+			l.newPage()
+			if !row.Header {
+				for _, header := range headers {
+					l.drawRow(header, header.layout(x, widths), border, x, tableWidth)
+				}
+				l.underline(headers, border, x, tableWidth)
+			}
+		}
+
+		l.drawRow(row, height, border, x, tableWidth)
+
+		if row.Header && (i+1 == len(rows) || !rows[i+1].Header) {
+			l.underline(headers, border, x, tableWidth)
+		}
+	}
+}
+
+// Rule off the header rows of a table without borders
+func (l *pdfLayout) underline(headers []*pdfTableRow, border bool, x float64, width float64) {
+	if len(headers) > 0 && !border {
+		l.page.line(x, l.y, x+width, l.y, pdfBorderColor, 0.75)
+	}
+}
+
+func (l *pdfLayout) drawRow(row *pdfTableRow, height float64, border bool, x float64, width float64) {
+
+	if row.Background != nil {
+		l.page.rect(x, l.y, width, height, row.Background, nil, 0)
+	}
+
+	for _, cell := range row.Cells {
+		var stroke *pdfColor
+		if border {
+			stroke = &pdfBorderColor
+		}
+		l.page.rect(cell.x, l.y, cell.width, height, cell.Style.Background, stroke, 0.5)
+
+		y := l.y + cellPaddingY
+		inner := cell.width - 2*cellPaddingX
+
+		for i, block := range cell.Blocks {
+			if block.Chart != nil {
+				drawChart(l.page, block.Chart, cell.x+cellPaddingX, y, chartScale(block.Chart, inner))
+				y += block.Chart.Height * chartScale(block.Chart, inner)
+				continue
+			}
+			for _, line := range cell.lines[i] {
+				line.draw(l.page, cell.x+cellPaddingX, y, inner, block.Align)
+				y += line.Height
+			}
+		}
+	}
+
+	l.y += height
+}
+
+// Wrap a row's cells to the column widths, returning the row's height
+func (row *pdfTableRow) layout(x float64, widths []float64) float64 {
+
+	height := 0.0
+	column := 0
+
+	for _, cell := range row.Cells {
+		cell.x, cell.width = x, 0
+		for i := 0; i < cell.Span && column < len(widths); i++ {
+			cell.width += widths[column]
+			column++
+		}
+		x += cell.width
+
+		inner := cell.width - 2*cellPaddingX
+
+		cell.lines = make([][]pdfLine, len(cell.Blocks))
+		cell.height = 2 * cellPaddingY
+
+		for i, block := range cell.Blocks {
+			if block.Chart != nil {
+				cell.height += block.Chart.Height * chartScale(block.Chart, inner)
+				continue
+			}
+			cell.lines[i] = wrapRuns(block.Runs, inner)
+			for _, line := range cell.lines[i] {
+				cell.height += line.Height
+			}
+		}
+
+		height = math.Max(height, cell.height)
+	}
+
+	return height
+}
+
+// Collect a table's rows, those of thead first and tfoot last
+func tableRows(table *htmlNode, s pdfStyle) []*pdfTableRow {
+
+	var head, body, foot []*pdfTableRow
+
+	add := func(rows []*pdfTableRow, tr *htmlNode, header bool, s pdfStyle) []*pdfTableRow {
+		row := tableRow(tr, header, s)
+		if len(row.Cells) == 0 {
+			return rows
+		}
+		return append(rows, row)
+	}
+
+	for _, c := range table.Children {
+		switch c.Tag {
+		case "thead", "tbody", "tfoot":
+			cs := c.style(s)
+			for _, tr := range c.Children {
+				if tr.Tag != "tr" {
+					continue
+				}
+				switch c.Tag {
+				case "thead":
+					head = add(head, tr, true, cs)
+				case "tbody":
+					body = add(body, tr, false, cs)
+				case "tfoot":
+					foot = add(foot, tr, false, cs)
+				}
+			}
+		case "tr":
+			body = add(body, c, false, s)
+		}
+	}
+
+	// Without a thead, leading rows of th cells are the header
+	if len(head) == 0 {
+		for len(body) > 0 && body[0].allHeadings {
+			body[0].Header = true
+			head, body = append(head, body[0]), body[1:]
+		}
+	}
+
+	return append(append(head, body...), foot...)
+}
+
+func tableRow(tr *htmlNode, header bool, s pdfStyle) *pdfTableRow {
+
+	rs := tr.style(s)
+	row := &pdfTableRow{Header: header, Background: rs.Background}
+	rs.Background = nil
+
+	headings := true
+
+	for _, td := range tr.Children {
+		if td.Tag != "td" && td.Tag != "th" {
+			continue
+		}
+		headings = headings && td.Tag == "th"
+
+		span, err := strconv.Atoi(td.Attrs["colspan"])
+		if err != nil || span < 1 {
+			span = 1
+		}
+
+		cs := td.style(rs)
+		row.Cells = append(row.Cells, &pdfTableCell{Span: span, Style: cs, Blocks: cellBlocks(td, cs, nil)})
+	}
+
+	row.allHeadings = headings && len(row.Cells) > 0
+
+	return row
+}
+
+// Flatten a cell's content to paragraphs and charts
+func cellBlocks(n *htmlNode, s pdfStyle, blocks []pdfCellBlock) []pdfCellBlock {
+
+	var runs []pdfRun
+
+	flush := func() {
+		if len(runs) > 0 {
+			blocks = append(blocks, pdfCellBlock{Runs: runs, Align: s.Align})
+			runs = nil
+		}
+	}
+
+	for _, c := range n.Children {
+		switch {
+		case htmlSkipped[c.Tag]:
+		case c.Tag == "svg":
+			flush()
+			blocks = append(blocks, pdfCellBlock{Chart: parseSVGChart(c), Align: s.Align})
+		case htmlBlocks[c.Tag]:
+			flush()
+			blocks = cellBlocks(c, c.style(s), blocks)
+		default:
+			runs = collectRuns(c, s, runs)
+		}
+	}
+
+	flush()
+
+	return blocks
+}
+
+// The widths of a cell's content on one line, and of its widest word or
+// smallest chart
+func (cell *pdfTableCell) measure() (float64, float64) {
+
+	natural, minimum := 0.0, 0.0
+
+	for _, block := range cell.Blocks {
+		if block.Chart != nil {
+			natural = math.Max(natural, block.Chart.Width*pdfPixel)
+			minimum = math.Max(minimum, block.Chart.Width*pdfPixel/3)
+			continue
+		}
+		n, m := measureRuns(block.Runs)
+		natural, minimum = math.Max(natural, n), math.Max(minimum, m)
+	}
+
+	return natural + 2*cellPaddingX, minimum + 2*cellPaddingX
+}
+
+// Work out column widths from the widths of their cells' content.  Columns
+// get their content's width if the table fits (and stretch to fill it if
+// fill is set), otherwise space is shared out over what they need beyond
+// their widest word.
+func columnWidths(rows []*pdfTableRow, width float64, fill bool) []float64 {
+
+	columns := 0
+	for _, row := range rows {
+		count := 0
+		for _, cell := range row.Cells {
+			count += cell.Span
+		}
+		if count > columns {
+			columns = count
+		}
+	}
+
+	natural := make([]float64, columns)
+	minimum := make([]float64, columns)
+
+	// Single column cells first, then spanning cells widen the columns
+	// they span if they need to
+	for _, spanning := range []bool{false, true} {
+		for _, row := range rows {
+			column := 0
+			for _, cell := range row.Cells {
+				if (cell.Span > 1) == spanning {
+					n, m := cell.measure()
+					widen(natural[column:column+cell.Span], n)
+					widen(minimum[column:column+cell.Span], m)
+				}
+				column += cell.Span
+			}
+		}
+	}
+
+	totalNatural, totalMinimum := sum(natural), sum(minimum)
+
+	widths := make([]float64, columns)
+
+	switch {
+	case totalNatural <= width:
+		extra := 0.0
+		if fill {
+			extra = width - totalNatural
+		}
+		for i := range widths {
+			if totalNatural > 0 {
+				widths[i] = natural[i] + extra*natural[i]/totalNatural
+			} else {
+				widths[i] = width / float64(columns)
+			}
+		}
+
+	case totalMinimum < width:
+		share := (width - totalMinimum) / (totalNatural - totalMinimum)
+		for i := range widths {
+			widths[i] = minimum[i] + (natural[i]-minimum[i])*share
+		}
+
+	default:
+		for i := range widths {
+			widths[i] = minimum[i] * width / totalMinimum
+		}
+	}
+
+	return widths
+}
+
+// Spread any more width a cell needs over the columns it spans
+func widen(columns []float64, width float64) {
+	if short := width - sum(columns); short > 0 {
+		for i := range columns {
+			columns[i] += short / float64(len(columns))
+		}
+	}
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,132 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The report periods that can be given by name, resolved against a date
+// (today for scheduled jobs).  Periods ending "to date" include that date.
+var periodPresets = []string{
+	"last-month", "month-to-date", "last-quarter", "quarter-to-date", "ytd", "last-year",
+	"last-12-months", "fiscal-ytd", "last-fiscal-year",
+}
+
+// Return the first and last days of a named period as of the given date.
+// Quarters are calendar quarters.  Fiscal years start on the first of
+// fiscalStart.
+func resolvePeriod(period string, asOf time.Time, fiscalStart time.Month) (start time.Time, end time.Time, err error) {
+
+	if fiscalStart < time.January || fiscalStart > time.December {
+		return start, end, fmt.Errorf("fiscal year start month must be 1 to 12, got %d", fiscalStart)
+	}
+
+	year, month, _ := asOf.Date()
+	loc := asOf.Location()
+
+	firstOf := func(year int, month time.Month) time.Time {
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	}
+
+	today := time.Date(year, month, asOf.Day(), 0, 0, 0, 0, loc)
+	thisMonth := firstOf(year, month)
+	thisQuarter := firstOf(year, month-(month-1)%3)
+
+	thisFiscalYear := firstOf(year, fiscalStart)
+	if month < fiscalStart {
+		thisFiscalYear = thisFiscalYear.AddDate(-1, 0, 0)
+	}
+
+	switch period {
+	case "last-month":
+		start, end = thisMonth.AddDate(0, -1, 0), thisMonth.AddDate(0, 0, -1)
+	case "month-to-date":
+		start, end = thisMonth, today
+	case "last-quarter":
+		start, end = thisQuarter.AddDate(0, -3, 0), thisQuarter.AddDate(0, 0, -1)
+	case "quarter-to-date":
+		start, end = thisQuarter, today
+	case "ytd":
+		start, end = firstOf(year, time.January), today
+	case "last-year":
+		start, end = firstOf(year-1, time.January), firstOf(year, time.January).AddDate(0, 0, -1)
+	case "last-12-months":
+		start, end = thisMonth.AddDate(-1, 0, 0), thisMonth.AddDate(0, 0, -1)
+	case "fiscal-ytd":
+		start, end = thisFiscalYear, today
+	case "last-fiscal-year":
+		start, end = thisFiscalYear.AddDate(-1, 0, 0), thisFiscalYear.AddDate(0, 0, -1)
+	default:
+		return start, end, fmt.Errorf("unknown period '%s', expected one of %s", period, strings.Join(periodPresets, ", "))
+	}
+
+	return start, end, nil
+}
+
+// Return the start and end of a period given by name (worked out as of the
+// asOf date, today if it's empty) or by start and end dates, as the -period,
+// -asof, -start and -end options.  The period ends at the end of its last
+// day.
+func periodDates(period string, asOf string, startDate string, endDate string, fiscalStart time.Month, loc *time.Location) (time.Time, time.Time, error) {
+
+	var start, end time.Time
+	var err error
+
+	if period != "" {
+		day := time.Now().In(loc)
+		if asOf != "" {
+			if day, err = parseDateOption("asof", asOf, loc); err != nil {
+				return start, end, err
+			}
+		}
+
+		if start, end, err = resolvePeriod(period, day, fiscalStart); err != nil {
+			return start, end, err
+		}
+	} else {
+		if start, err = parseDateOption("start", startDate, loc); err != nil {
+			return start, end, err
+		}
+		if end, err = parseDateOption("end", endDate, loc); err != nil {
+			return start, end, err
+		}
+		if end.Before(start) {
+			return start, end, errors.New("-end is before -start")
+		}
+	}
+
+	year, month, day := end.Date()
+	return start, time.Date(year, month, day, 23, 59, 59, 0, loc), nil
+}
+
+// Parse a yyyy-mm-dd date given for the named option
+func parseDateOption(option string, value string, loc *time.Location) (time.Time, error) {
+
+	date, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(value), loc)
+	if err != nil {
+		return date, fmt.Errorf("bad -%s date '%s', expected yyyy-mm-dd", option, value)
+	}
+
+	return date, nil
+}
@@ -0,0 +1,359 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// A regular interval txns can recur on
+type Cadence struct {
+	Name    string
+	Days    float64 // Typical gap between txns
+	Slack   float64 // How far (in days) a gap can stray from Days
+	MinTxns int     // Fewest txns needed to call it a series
+
+	next func(t time.Time) time.Time
+}
+
+// Checked in order, the first cadence that fits wins
+var cadences = []Cadence{
+	{"weekly", 7, 1, 4, func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }},
+	{"biweekly", 14, 2, 3, func(t time.Time) time.Time { return t.AddDate(0, 0, 14) }},
+	{"monthly", 30.44, 4, 3, func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"annual", 365.25, 10, 2, func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+// Return the cadence called name, nil if there isn't one
+func findCadence(name string) *Cadence {
+	for i := range cadences {
+		if cadences[i].Name == name {
+			return &cadences[i]
+		}
+	}
+	return nil
+}
+
+// How strict detection is
+type RecurringOptions struct {
+	AmountTolerance float64        // Fraction an amount can differ from the typical amount
+	MinFit          float64        // Fraction of gaps and amounts that must fit
+	AsOf            time.Time      // Detect as of, series not seen for two cadences before have lapsed
+	Location        *time.Location // The FI's timezone, which days are reckoned in
+}
+
+func (o *RecurringOptions) location() *time.Location {
+	if o.Location == nil {
+		return time.UTC
+	}
+	return o.Location
+}
+
+// One occurrence in a candidate series, txns on the same day are combined
+type occurrence struct {
+	At     time.Time
+	Amount int64
+}
+
+// Look for a recurring series in a txn group's txns of one type (sorted by
+// time).  Returns nil if they don't recur regularly.
+func detect_recurring(txns []occurrence, opts *RecurringOptions) *RecurringSeries {
+
+	txns = combine_same_day(txns, opts.location())
+	if len(txns) < 2 {
+		return nil
+	}
+
+	gaps := make([]float64, len(txns)-1)
+	for i := 1; i < len(txns); i++ {
+		gaps[i-1] = txns[i].At.Sub(txns[i-1].At).Hours() / 24
+	}
+
+	typicalGap := median(gaps)
+
+	var cadence *Cadence
+	for i := range cadences {
+		if math.Abs(typicalGap-cadences[i].Days) <= cadences[i].Slack {
+			cadence = &cadences[i]
+			break
+		}
+	}
+
+	if cadence == nil || len(txns) < cadence.MinTxns {
+		return nil
+	}
+
+	fitting := 0
+	for _, gap := range gaps {
+		if math.Abs(gap-cadence.Days) <= cadence.Slack {
+			fitting++
+		}
+	}
+
+	if float64(fitting) < opts.MinFit*float64(len(gaps)) {
+		return nil
+	}
+
+	amounts := make([]float64, len(txns))
+	for i, txn := range txns {
+		amounts[i] = float64(txn.Amount)
+	}
+
+	typical := median(amounts)
+
+	fitting = 0
+	for _, amount := range amounts {
+		if math.Abs(amount-typical) <= opts.AmountTolerance*math.Abs(typical) {
+			fitting++
+		}
+	}
+
+	if float64(fitting) < opts.MinFit*float64(len(amounts)) {
+		return nil
+	}
+
+	last := txns[len(txns)-1]
+
+	if !opts.AsOf.IsZero() && opts.AsOf.Sub(last.At).Hours()/24 > 2*cadence.Days+cadence.Slack {
+		return nil // Lapsed, probably cancelled
+	}
+
+	// The most recent amounts are the best guess at the next, prices change
+	recent := amounts
+	if len(recent) > 3 {
+		recent = recent[len(recent)-3:]
+	}
+
+	return &RecurringSeries{
+		Cadence:      cadence.Name,
+		Amount:       int64(typical),
+		Occurrences:  len(txns),
+		FirstSeen:    txns[0].At,
+		LastSeen:     last.At,
+		LastAmount:   last.Amount,
+		NextExpected: cadence.next(last.At),
+		NextAmount:   int64(median(recent)),
+	}
+}
+
+// Combine the txns on each day in loc
+func combine_same_day(txns []occurrence, loc *time.Location) []occurrence {
+
+	var combined []occurrence
+
+	for _, txn := range txns {
+		n := len(combined)
+		if n > 0 && sameDay(combined[n-1].At, txn.At, loc) {
+			combined[n-1].Amount += txn.Amount
+			continue
+		}
+		combined = append(combined, txn)
+	}
+
+	return combined
+}
+
+func sameDay(a time.Time, b time.Time, loc *time.Location) bool {
+	ay, am, ad := a.In(loc).Date()
+	by, bm, bd := b.In(loc).Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// Return the median of values
+func median(values []float64) float64 {
+
+	if len(values) == 0 {
+		return 0
+	}
+
+	values = append([]float64(nil), values...)
+	sort.Float64s(values)
+
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+
+	return values[mid]
+}
+
+// Scan the txns (occurring between since and opts.AsOf) of every account
+// group, or just of accountGroupId if not empty, and replace the stored
+// recurring series with those found.  A scan of every account group also
+// drops the series of account groups with no txns in that time.  Calls
+// progress with each account group done and returns the number of series
+// found.
+func detect_all_recurring(accountGroupId string, since time.Time, opts *RecurringOptions, dbm *gorp.DbMap, progress func(agid string, found int)) (int, error) {
+
+	query := "SELECT t.account_group_id, t.txn_group_id, t.txn_type, t.occurred_at, t.amount, " +
+		"coalesce(g.label, ''), coalesce(g.group_type, ''), coalesce(g.classification, '') " +
+		"FROM txns t JOIN txn_groups g ON g.id = t.txn_group_id " +
+		"WHERE t.occurred_at >= $1 AND t.occurred_at <= $2 AND ($3 = '' OR t.account_group_id = $3) " +
+		"ORDER BY t.account_group_id, t.txn_group_id, t.txn_type, t.occurred_at"
+
+	rows, err := dbm.Db.Query(query, since, opts.AsOf, accountGroupId)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	total := 0
+
+	var current *RecurringSeries // Key and labels for the txns being collected
+	var txns []occurrence
+	var found []*RecurringSeries
+	seen := map[string]bool{}
+
+	finishSeries := func() {
+		if current == nil {
+			return
+		}
+
+		if series := detect_recurring(txns, opts); series != nil {
+			series.AccountGroupId = current.AccountGroupId
+			series.TxnGroupId = current.TxnGroupId
+			series.TxnType = current.TxnType
+			series.Label = current.Label
+			series.TxnGroupType = current.TxnGroupType
+			series.Classification = current.Classification
+			found = append(found, series)
+		}
+
+		txns = nil
+	}
+
+	finishAccountGroup := func() error {
+		if current == nil {
+			return nil
+		}
+
+		if err := store_recurring_series(current.AccountGroupId, found, dbm); err != nil {
+			return err
+		}
+
+		seen[current.AccountGroupId] = true
+		total += len(found)
+		if progress != nil {
+			progress(current.AccountGroupId, len(found))
+		}
+
+		found = nil
+		return nil
+	}
+
+	for rows.Next() {
+		row := &RecurringSeries{}
+		var at time.Time
+		var amount int64
+
+		if err := rows.Scan(&row.AccountGroupId, &row.TxnGroupId, &row.TxnType, &at, &amount,
+			&row.Label, &row.TxnGroupType, &row.Classification); err != nil {
+			return total, err
+		}
+
+		if current == nil || row.AccountGroupId != current.AccountGroupId || row.TxnGroupId != current.TxnGroupId || row.TxnType != current.TxnType {
+			finishSeries()
+
+			if current != nil && row.AccountGroupId != current.AccountGroupId {
+				if err := finishAccountGroup(); err != nil {
+					return total, err
+				}
+			}
+
+			current = row
+		}
+
+		// The driver returns UTC, days and months are the FI's
+		txns = append(txns, occurrence{at.In(opts.location()), amount})
+	}
+
+	if err := rows.Err(); err != nil {
+		return total, err
+	}
+
+	finishSeries()
+	if err := finishAccountGroup(); err != nil {
+		return total, err
+	}
+
+	if current == nil && accountGroupId != "" {
+		// No txns left, so no series either
+		if err := store_recurring_series(accountGroupId, nil, dbm); err != nil {
+			return total, err
+		}
+	}
+
+	if accountGroupId == "" {
+		if err := drop_stale_recurring_series(seen, dbm); err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// Delete the recurring series of account groups that aren't in seen
+func drop_stale_recurring_series(seen map[string]bool, dbm *gorp.DbMap) error {
+
+	var stored []string
+	if _, err := dbm.Select(&stored, "SELECT DISTINCT account_group_id FROM recurring_series"); err != nil {
+		return err
+	}
+
+	for _, agid := range stored {
+		if seen[agid] {
+			continue
+		}
+
+		if err := store_recurring_series(agid, nil, dbm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replace an account group's recurring series
+func store_recurring_series(accountGroupId string, series []*RecurringSeries, dbm *gorp.DbMap) error {
+
+	tx, err := dbm.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recurring_series WHERE account_group_id = $1", accountGroupId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, s := range series {
+		s.Created = time.Now().UnixNano()
+		if err := tx.Insert(s); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
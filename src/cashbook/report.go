@@ -23,7 +23,6 @@ import (
 	"time"
     "github.com/coopernurse/gorp"
 	"log"
-	"fmt"
 )
 
 const shortForm = "2006-01-02 15:04:05"
@@ -36,9 +35,15 @@ type ReportingApi struct {
 	// Query for results before this time
 	PeriodEnd time.Time
 
-	// If non-nil, queries will be scoped to this account group, other wise will query FI wide
+	// The timezone periods (days, months) are reckoned in
+	Location *time.Location
+
+	// If non-empty, queries will be scoped to this account group, other wise will query FI wide
 	AccountGroupId string
 
+	// Who benchmarks compare the account group with, all members if nil
+	Cohort *Cohort
+
 	dbmap *gorp.DbMap
 }
 
@@ -56,12 +61,13 @@ func (r *ReportingApi) TxnTypeSummary(txnType string) *TxnSummary {
 
 	summary := TxnSummary{} 
 
-	err := r.dbmap.SelectOne(&summary, "SELECT count(*) as count, coalesce(sum(amount), 0) as sum FROM txns WHERE account_group_id = :id AND txn_type = :type AND occurred_at BETWEEN :rstart AND :rend",
-		map[string]interface{} { 
-			"type": txnType,
-			"id": r.AccountGroupId,
-		    "rstart": r.PeriodStart,
-			"rend": r.PeriodEnd})
+	params := r.queryParams()
+	params["type"] = txnType
+
+	totals := r.totals()
+
+	err := r.dbmap.SelectOne(&summary, "SELECT coalesce(sum(" + totals.count + "), 0) as count, coalesce(sum(amount), 0) as sum FROM " + totals.table + " WHERE " + r.txnScope() + " AND txn_type = :type AND " + totals.period,
+		params)
 
 	if err != nil {
 		log.Printf("Error getting TxnGroupSummary: %v", err);
@@ -75,12 +81,13 @@ func (r *ReportingApi) TxnTypeSummary(txnType string) *TxnSummary {
 func (r *ReportingApi) TxnGroupTypeSummary(txnGroupType string) *TxnSummary {
 	summary := TxnSummary{} 
 
-	err := r.dbmap.SelectOne(&summary, "SELECT count(*) as count, coalesce(sum(amount), 0) as sum FROM txns WHERE account_group_id = :id AND txn_group_type = :type AND occurred_at BETWEEN :rstart AND :rend",
-		map[string]interface{} { 
-			"type": txnGroupType,
-			"id": r.AccountGroupId,
-		    "rstart": r.PeriodStart,
-			"rend": r.PeriodEnd})
+	params := r.queryParams()
+	params["type"] = txnGroupType
+
+	totals := r.totals()
+
+	err := r.dbmap.SelectOne(&summary, "SELECT coalesce(sum(" + totals.count + "), 0) as count, coalesce(sum(amount), 0) as sum FROM " + totals.table + " WHERE " + r.txnScope() + " AND txn_group_type = :type AND " + totals.period,
+		params)
 
 	if err != nil {
 		log.Printf("Error getting TxnGroupSummary: %v", err);
@@ -90,30 +97,48 @@ func (r *ReportingApi) TxnGroupTypeSummary(txnGroupType string) *TxnSummary {
 	return &summary
 }
 
-// Return a count and sum for the set of transactions grouped by Indusrty Classification
+// Return a count and sum for the set of transactions grouped by Industry Classification (Dining, Groceries, etc)
+func (r *ReportingApi) ClassificationSummary(classification string) *TxnSummary {
+	summary := TxnSummary{}
+
+	params := r.queryParams()
+	params["classification"] = classification
+
+	totals := r.totals()
+
+	err := r.dbmap.SelectOne(&summary, "SELECT coalesce(sum(" + totals.count + "), 0) as count, coalesce(sum(amount), 0) as sum FROM " + totals.table + " WHERE " + r.txnScope() + " AND classification = :classification AND " + totals.period,
+		params)
+
+	if err != nil {
+		log.Printf("Error getting ClassificationSummary: %v", err);
+		return nil
+	}
+
+	return &summary
+}
+
+// Return a count and sum for the set of transactions in a txn group, net of any txns of the other type
 func (r *ReportingApi) TxnGroupSummary(txnGroupId int64, txnType string) *TxnSummary {
 
 	expenses := TxnSummary{} 
 	deposits := TxnSummary{} // Sometimes there are deposits for the txnGroup (refunds, etc)
 
-	err := r.dbmap.SelectOne(&expenses, "SELECT count(*) as count, coalesce(sum(amount), 0) as sum FROM txns WHERE txn_group_id = :id AND txn_type = :type AND occurred_at BETWEEN :rstart AND :rend",
-		map[string]interface{} { 
-			"type": txnType,
-			"id": txnGroupId,
-		    "rstart": r.PeriodStart,
-			"rend": r.PeriodEnd})
+	params := r.queryParams()
+	params["type"] = txnType
+	params["id"] = txnGroupId
+
+	totals := r.totals()
+
+	err := r.dbmap.SelectOne(&expenses, "SELECT coalesce(sum(" + totals.count + "), 0) as count, coalesce(sum(amount), 0) as sum FROM " + totals.table + " WHERE " + r.txnGroupColumn() + " = :id AND txn_type = :type AND " + totals.period,
+		params)
 
 	if err != nil {
 		log.Printf("Error getting TxnGroupSummary: %v", err);
 		return nil
 	}
 
-	err = r.dbmap.SelectOne(&deposits, "SELECT count(*) as count, coalesce(sum(amount), 0) as sum FROM txns WHERE txn_group_id = :id AND txn_type != :type AND occurred_at BETWEEN :rstart AND :rend",
-		map[string]interface{} { 
-			"type": txnType,
-			"id": txnGroupId,
-		    "rstart": r.PeriodStart,
-			"rend": r.PeriodEnd})
+	err = r.dbmap.SelectOne(&deposits, "SELECT coalesce(sum(" + totals.count + "), 0) as count, coalesce(sum(amount), 0) as sum FROM " + totals.table + " WHERE " + r.txnGroupColumn() + " = :id AND txn_type != :type AND " + totals.period,
+		params)
 
 	if err != nil {
 		log.Printf("Error getting TxnGroupSummary: %v", err);
@@ -127,7 +152,7 @@ func (r *ReportingApi) TxnGroupSummary(txnGroupId int64, txnType string) *TxnSum
 }
 
 
-// Return the set of txn groups for the FI or for the AccountGroupId
+// Return the set of txn groups for the FI (the system groups) or for the AccountGroupId
 func (r *ReportingApi) TxnGroups(group_type string) []TxnGroup {
 
 	//Ideally, this should return only groups that have txns in the date range (or maybe two entry points, or a param)
@@ -146,6 +171,49 @@ func (r *ReportingApi) TxnGroups(group_type string) []TxnGroup {
 	return groups
 }
 
+// True when reporting on the whole FI rather than a single account group
+func (r *ReportingApi) IsSystem() bool {
+	return r.AccountGroupId == ""
+}
+
+// The parameters common to most queries: the account group, period (and its
+// months, for summaries) and timezone
+func (r *ReportingApi) queryParams() map[string]interface{} {
+	mstart, mend, _ := r.summaryMonths()
+
+	return map[string]interface{} {
+		"id": r.AccountGroupId,
+		"rstart": r.PeriodStart,
+		"rend": r.PeriodEnd,
+		"mstart": mstart,
+		"mend": mend,
+		"tz": r.location().String()}
+}
+
+func (r *ReportingApi) location() *time.Location {
+	if r.Location == nil {
+		return time.UTC
+	}
+	return r.Location
+}
+
+// The where clause restricting txns to the account group being reported on
+func (r *ReportingApi) txnScope() string {
+	if r.IsSystem() {
+		return "TRUE"
+	}
+	return "account_group_id = :id"
+}
+
+// The txns column referencing the kind of txn group TxnGroups returns, system
+// reports work with the system txn groups.
+func (r *ReportingApi) txnGroupColumn() string {
+	if r.IsSystem() {
+		return "system_txn_group_id"
+	}
+	return "txn_group_id"
+}
+
 func (r *ReportingApi) StartDate() string {
 	return r.PeriodStart.Format("January 2, 2006")
 }
@@ -163,10 +231,3 @@ func (r *ReportingApi) SampleMethod(param string) string {
 }
 
 
-func currency(amount int) string {
-	dollars := amount / 100
-	cents := amount % 100
-
-	return fmt.Sprintf("$%d.%02d", dollars, cents)
-}
-
@@ -0,0 +1,88 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// Return the anomalies (as found by anomalies:detect) of kind (txn,
+// txn_group or classification, empty for all) in months starting during the
+// report period, most unusual first.
+func (r *ReportingApi) Anomalies(kind string) []Anomaly {
+	return r.anomalies(kind, 0)
+}
+
+// Return at most limit of the most unusual anomalies of kind
+func (r *ReportingApi) TopAnomalies(kind string, limit int) []Anomaly {
+	return r.anomalies(kind, limit)
+}
+
+func (r *ReportingApi) anomalies(kind string, limit int) []Anomaly {
+
+	var anomalies []Anomaly
+
+	params := r.queryParams()
+	params["kind"] = kind
+	params["limit"] = limit
+
+	query := "SELECT * FROM anomalies WHERE " + r.txnScope() +
+		" AND (:kind = '' OR kind = :kind) AND period_start BETWEEN :rstart AND :rend ORDER BY score DESC, ratio DESC"
+
+	if limit > 0 {
+		query += " LIMIT :limit"
+	}
+
+	_, err := r.dbmap.Select(&anomalies, query, params)
+
+	if err != nil {
+		log.Printf("Error getting Anomalies: %v", err)
+		return nil
+	}
+
+	// In the report's timezone, as TxnDetails are
+	for i := range anomalies {
+		anomalies[i].PeriodStart = anomalies[i].PeriodStart.In(r.location())
+		anomalies[i].OccurredAt = anomalies[i].OccurredAt.In(r.location())
+	}
+
+	return anomalies
+}
+
+// Return how many times usual the amount is, rounded for wording such as
+// "3x your usual" or "2.5x your usual"
+func (a Anomaly) Times() string {
+	if a.Ratio >= 10 {
+		return fmt.Sprintf("%.0fx", a.Ratio)
+	}
+
+	rounded := math.Floor(a.Ratio*2) / 2 // Halves, rounded down so we never overstate
+	if rounded == math.Floor(rounded) {
+		return fmt.Sprintf("%.0fx", rounded)
+	}
+	return fmt.Sprintf("%.1fx", rounded)
+}
+
+// Return the amount over usual, in pennies
+func (a Anomaly) Excess() int {
+	return int(a.Amount - a.Usual)
+}
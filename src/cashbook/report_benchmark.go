@@ -0,0 +1,279 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+)
+
+// The account groups members are benchmarked against: every one (the FI) or
+// a segment read from a cohort file.  The spending of its members is queried
+// once per period and kept, so the reports of a run share it.
+type Cohort struct {
+	Name string
+
+	members map[string]bool // nil for every account group
+
+	mu    sync.Mutex
+	cache map[string]*peerSpending
+}
+
+// Every account group, the cohort of ReportingApis without one
+var allMembers = NewCohort("all members", nil)
+
+// Return a cohort of the given account groups, or of every account group if
+// ids is nil
+func NewCohort(name string, ids []string) *Cohort {
+
+	cohort := &Cohort{Name: name, cache: map[string]*peerSpending{}}
+
+	if ids != nil {
+		cohort.members = map[string]bool{}
+		for _, id := range ids {
+			cohort.members[id] = true
+		}
+	}
+
+	return cohort
+}
+
+// What each member of a cohort spent in a period, by txn group type or
+// classification
+type peerSpending struct {
+	members map[string]bool             // With spending in the period
+	spent   map[string]map[string]int64 // Key (Dining) to account group to spend
+}
+
+// How the spending of a cohort's members on a classification or txn group
+// type is distributed, and where the account group being reported on sits.
+// Members who spent nothing on it count as spending 0.
+type Benchmark struct {
+	Cohort string
+	Key    string // The classification or txn group type
+
+	Members  int // Who spent anything in the period
+	Spenders int // Who spent on Key
+
+	Average int
+	Median  int
+	P25     int
+	P75     int
+	P90     int
+
+	Amount int // The account group's spend, 0 in system reports
+	Below  int // Percent of members spending less than the account group
+	Above  int // Percent of members spending more
+
+	values []int64 // Every member's spend, smallest first
+}
+
+// Return the spend the given percent (0 to 100) of members are at or below
+func (b *Benchmark) Percentile(percent int) int {
+	return int(percentile(b.values, float64(percent)))
+}
+
+// Return how a cohort's spending on a classification (Dining, Groceries,
+// etc) is distributed over the period and where the account group sits:
+//
+//	{{with $api.ClassificationBenchmark "Dining"}}You spend less on Dining than {{.Above}}% of members{{end}}
+func (r *ReportingApi) ClassificationBenchmark(classification string) *Benchmark {
+	return r.benchmark("classification", classification)
+}
+
+// Return how a cohort's spending on a txn group type (Bills, Retail, etc)
+// is distributed over the period and where the account group sits
+func (r *ReportingApi) TxnGroupTypeBenchmark(txnGroupType string) *Benchmark {
+	return r.benchmark("txn_group_type", txnGroupType)
+}
+
+// Return a Benchmark for each classification the cohort spent on, by name
+func (r *ReportingApi) ClassificationBenchmarks() []Benchmark {
+	return r.benchmarks("classification")
+}
+
+// Return a Benchmark for each txn group type the cohort spent on, by name
+func (r *ReportingApi) TxnGroupTypeBenchmarks() []Benchmark {
+	return r.benchmarks("txn_group_type")
+}
+
+func (r *ReportingApi) cohort() *Cohort {
+	if r.Cohort == nil {
+		return allMembers
+	}
+	return r.Cohort
+}
+
+func (r *ReportingApi) benchmark(column string, key string) *Benchmark {
+
+	spending, err := r.cohort().spending(column, r)
+	if err != nil {
+		log.Printf("Error getting Benchmark: %v", err)
+		return nil
+	}
+
+	return r.cohort().benchmark(spending, key, r.AccountGroupId)
+}
+
+func (r *ReportingApi) benchmarks(column string) []Benchmark {
+
+	cohort := r.cohort()
+
+	spending, err := cohort.spending(column, r)
+	if err != nil {
+		log.Printf("Error getting Benchmarks: %v", err)
+		return nil
+	}
+
+	keys := make([]string, 0, len(spending.spent))
+	for key := range spending.spent {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	benchmarks := []Benchmark{}
+	for _, key := range keys {
+		benchmarks = append(benchmarks, *cohort.benchmark(spending, key, r.AccountGroupId))
+	}
+
+	return benchmarks
+}
+
+// Return what the cohort's members spent by column over the report's period,
+// querying it the first time it's asked for
+func (c *Cohort) spending(column string, r *ReportingApi) (*peerSpending, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cacheKey := column + " " + r.PeriodStart.String() + " " + r.PeriodEnd.String()
+	if spending, ok := c.cache[cacheKey]; ok {
+		return spending, nil
+	}
+
+	var totals []struct {
+		AccountGroupId string `db:"account_group_id"`
+		Key            string
+		Sum            int64
+	}
+
+	source := r.totals()
+
+	_, err := r.dbmap.Select(&totals, "SELECT account_group_id, coalesce("+column+", '') as key, sum(amount) as sum FROM "+source.table+
+		" WHERE txn_type = 'W' AND "+source.period+" GROUP BY 1, 2", r.queryParams())
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Account groups outside the cohort are kept, for reports on them
+	spending := &peerSpending{members: map[string]bool{}, spent: map[string]map[string]int64{}}
+
+	for _, total := range totals {
+		if c.members == nil || c.members[total.AccountGroupId] {
+			spending.members[total.AccountGroupId] = true
+		}
+
+		if total.Key == "" {
+			continue
+		}
+
+		if spending.spent[total.Key] == nil {
+			spending.spent[total.Key] = map[string]int64{}
+		}
+		spending.spent[total.Key][total.AccountGroupId] += total.Sum
+	}
+
+	c.cache[cacheKey] = spending
+
+	return spending, nil
+}
+
+// Work out the distribution of spending on key and where the account group
+// (if any) sits in it
+func (c *Cohort) benchmark(spending *peerSpending, key string, accountGroupId string) *Benchmark {
+
+	spent := spending.spent[key]
+
+	b := &Benchmark{Cohort: c.Name, Key: key, Members: len(spending.members)}
+
+	total := int64(0)
+	for id, amount := range spent {
+		if spending.members[id] {
+			b.values = append(b.values, amount)
+			total += amount
+		}
+	}
+	b.Spenders = len(b.values)
+
+	// Those who didn't spend on key spent 0
+	for len(b.values) < b.Members {
+		b.values = append(b.values, 0)
+	}
+	sort.Slice(b.values, func(i, j int) bool { return b.values[i] < b.values[j] })
+
+	if b.Members == 0 {
+		return b
+	}
+
+	b.Average = int(math.Round(float64(total) / float64(b.Members)))
+	b.Median = b.Percentile(50)
+	b.P25 = b.Percentile(25)
+	b.P75 = b.Percentile(75)
+	b.P90 = b.Percentile(90)
+
+	if accountGroupId == "" {
+		return b
+	}
+
+	b.Amount = int(spent[accountGroupId])
+
+	below := sort.Search(len(b.values), func(i int) bool { return b.values[i] >= int64(b.Amount) })
+	above := len(b.values) - sort.Search(len(b.values), func(i int) bool { return b.values[i] > int64(b.Amount) })
+
+	b.Below = below * 100 / b.Members
+	b.Above = above * 100 / b.Members
+
+	return b
+}
+
+// Return the value the given percent of the sorted values are at or below,
+// interpolating between neighbours
+func percentile(sorted []int64, percent float64) float64 {
+
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := percent / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	if lower < 0 {
+		return float64(sorted[0])
+	}
+	if upper >= len(sorted) {
+		return float64(sorted[len(sorted)-1])
+	}
+
+	return float64(sorted[lower]) + (rank-float64(lower))*float64(sorted[upper]-sorted[lower])
+}
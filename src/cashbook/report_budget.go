@@ -0,0 +1,193 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Spending in a category over one of its limit intervals
+type BudgetStatus struct {
+	Category Category
+
+	// The interval runs from IntervalStart up to (but not including) IntervalEnd
+	IntervalStart time.Time
+	IntervalEnd   time.Time
+
+	Limit       int     // 0 when the category has no limit
+	Spent       int     // Withdrawals net of deposits (refunds, etc)
+	Remaining   int     // Negative once over budget
+	PercentUsed float64 // 0 when there is no limit
+	OverBudget  bool
+}
+
+// The columns of a category, shared categories (with no account group)
+// having an empty AccountGroupId
+const categoryColumns = "id, label, spending_limit, limit_interval, coalesce(account_group_id, '') as account_group_id, created"
+
+// Return the account group's categories along with the shared ones
+func (r *ReportingApi) Categories() []Category {
+
+	var categories []Category
+
+	_, err := r.dbmap.Select(&categories, "SELECT "+categoryColumns+" FROM categories "+
+		"WHERE account_group_id = :id OR account_group_id IS NULL ORDER BY label",
+		r.queryParams())
+
+	if err != nil {
+		log.Printf("Error getting Categories: %v", err)
+		return nil
+	}
+
+	return categories
+}
+
+// Return the spending against the limit of each of the account group's
+// categories (and the shared ones), for each limit interval overlapping the report period.
+func (r *ReportingApi) Budgets() []BudgetStatus {
+
+	var statuses []BudgetStatus
+
+	for _, category := range r.Categories() {
+		statuses = append(statuses, r.budgetStatuses(category)...)
+	}
+
+	return statuses
+}
+
+// Return the spending against a single category's limit for each limit
+// interval overlapping the report period.  The category must be the account
+// group's own or a shared one (with no account group).
+func (r *ReportingApi) CategoryBudget(categoryId int64) []BudgetStatus {
+
+	category := Category{}
+
+	err := r.dbmap.SelectOne(&category, "SELECT "+categoryColumns+" FROM categories WHERE id = :category",
+		map[string]interface{}{"category": categoryId})
+
+	if err != nil {
+		log.Printf("Error getting CategoryBudget: %v", err)
+		return nil
+	}
+
+	if !r.IsSystem() && category.AccountGroupId != "" && category.AccountGroupId != r.AccountGroupId {
+		log.Printf("Error getting CategoryBudget: category %d belongs to another account group", categoryId)
+		return nil
+	}
+
+	return r.budgetStatuses(category)
+}
+
+// Return a count and sum for the period's txns in a category, withdrawals net of deposits
+func (r *ReportingApi) CategorySummary(categoryId int64) *TxnSummary {
+	return r.categorySpend(categoryId, r.PeriodStart, r.PeriodEnd.Add(time.Second))
+}
+
+func (r *ReportingApi) budgetStatuses(category Category) []BudgetStatus {
+
+	var statuses []BudgetStatus
+
+	for _, interval := range r.limitIntervals(category.LimitInterval) {
+		spent := r.categorySpend(category.Id, interval[0], interval[1])
+		if spent == nil {
+			return nil
+		}
+
+		status := BudgetStatus{
+			Category:      category,
+			IntervalStart: interval[0],
+			IntervalEnd:   interval[1],
+			Limit:         int(category.SpendingLimit),
+			Spent:         spent.Sum,
+			Remaining:     int(category.SpendingLimit) - spent.Sum,
+		}
+
+		if status.Limit > 0 {
+			status.PercentUsed = float64(status.Spent) * 100 / float64(status.Limit)
+			status.OverBudget = status.Spent > status.Limit
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// Return the [start, end) limit intervals overlapping the report period.  The
+// usual lengths follow the calendar (weeks start Monday), others are counted
+// in days from the start of the period.
+func (r *ReportingApi) limitIntervals(days int) [][2]time.Time {
+
+	var intervals [][2]time.Time
+
+	calendar := map[int]string{LimitDaily: "day", LimitWeekly: "week", LimitMonthly: "month", LimitYearly: "year"}
+
+	if days < 1 {
+		// No interval, the limit applies to the period as a whole
+		return append(intervals, [2]time.Time{r.PeriodStart, r.PeriodEnd.Add(time.Second)})
+	}
+
+	if interval, ok := calendar[days]; ok {
+		for t := truncateTime(r.PeriodStart.In(r.location()), interval); !t.After(r.PeriodEnd); t = nextInterval(t, interval) {
+			intervals = append(intervals, [2]time.Time{t, nextInterval(t, interval)})
+		}
+		return intervals
+	}
+
+	for t := r.PeriodStart; !t.After(r.PeriodEnd); t = t.AddDate(0, 0, days) {
+		intervals = append(intervals, [2]time.Time{t, t.AddDate(0, 0, days)})
+	}
+
+	return intervals
+}
+
+// The account group's withdrawals net of deposits in a category between
+// start and end.  Whole months are read from the monthly summaries, which
+// have no category but whose txn groups do.
+func (r *ReportingApi) categorySpend(categoryId int64, start time.Time, end time.Time) *TxnSummary {
+
+	summary := TxnSummary{}
+
+	api := r.withPeriod(start, end.Add(-time.Second))
+	t := api.totals()
+
+	params := api.queryParams()
+	params["category"] = categoryId
+	params["start"] = start
+	params["end"] = end
+
+	where := "category_id = :category AND occurred_at >= :start AND occurred_at < :end"
+	if t.table == txnTotalsFromSummaries.table {
+		where = "txn_group_id IN (SELECT id FROM txn_groups WHERE category_id = :category) AND " + t.period
+	}
+
+	err := r.dbmap.SelectOne(&summary, "SELECT coalesce(sum(CASE WHEN txn_type = 'W' THEN "+t.count+" ELSE -"+t.count+" END), 0) as count, "+
+		"coalesce(sum(CASE WHEN txn_type = 'W' THEN amount ELSE -amount END), 0) as sum "+
+		"FROM "+t.table+" WHERE "+r.txnScope()+" AND "+where,
+		params)
+
+	if err != nil {
+		log.Printf("Error getting category spending: %v", err)
+		return nil
+	}
+
+	return &summary
+}
@@ -0,0 +1,220 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+)
+
+// Template functions drawing ReportingApi results as inline SVG charts:
+//
+//	{{pieChart ($api.TopTxnGroups "W" 5) "title=Top merchants" "width=300"}}
+//	{{barChart $api.Budgets "value=spent" "colors=#336699"}}
+//	{{lineChart ($api.TxnTypeSeries "month" "W") "labels=false"}}
+//
+// See guide/Authoring Reports.md for the data and options they take.  Values
+// are formatted for the locale.
+func chartFuncMap(locale *Locale) map[string]interface{} {
+	return map[string]interface{}{
+		"pieChart":  chartFunc("pie", locale, pieChart),
+		"barChart":  chartFunc("bar", locale, barChart),
+		"lineChart": chartFunc("line", locale, lineChart),
+		"chartData": chartData,
+	}
+}
+
+func chartFunc(kind string, locale *Locale, layout func(data []ChartDatum, opts *ChartOptions) *Chart) func(data interface{}, options ...string) (template.HTML, error) {
+	return func(data interface{}, options ...string) (template.HTML, error) {
+
+		opts, err := parseChartOptions(kind, locale, options)
+		if err != nil {
+			return "", err
+		}
+
+		datums, err := toChartData(data, opts)
+		if err != nil {
+			return "", err
+		}
+
+		return template.HTML(layout(datums, opts).SVG()), nil
+	}
+}
+
+// Build chart data from label, value pairs, the values being numbers or
+// Summaries (charting their Sum):
+//
+//	{{pieChart (chartData "Retail" ($api.TxnGroupTypeSummary "Retail") "Bills" ($api.TxnGroupTypeSummary "Bills"))}}
+func chartData(pairs ...interface{}) ([]ChartDatum, error) {
+
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("chartData takes label, value pairs, got %d arguments", len(pairs))
+	}
+
+	var data []ChartDatum
+
+	for i := 0; i < len(pairs); i += 2 {
+		label, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("chartData label %v is not a string", pairs[i])
+		}
+
+		var value float64
+
+		switch v := pairs[i+1].(type) {
+		case int:
+			value = float64(v)
+		case int64:
+			value = float64(v)
+		case float64:
+			value = v
+		case *TxnSummary:
+			if v != nil {
+				value = float64(v.Sum)
+			}
+		default:
+			return nil, fmt.Errorf("chartData can't chart a %T", v)
+		}
+
+		data = append(data, ChartDatum{label, value})
+	}
+
+	return data, nil
+}
+
+// Convert ReportingApi results to chart data.  Which of their values is
+// charted is chosen with the value option: sum (the default) or count for
+// Summaries, txn group totals and series; spent, limit or remaining for
+// budgets; amount or usual for anomalies; cumulative (the default), net,
+// inflow or outflow for forecasts.  Counts aren't currency.
+func toChartData(data interface{}, opts *ChartOptions) ([]ChartDatum, error) {
+
+	var datums []ChartDatum
+
+	pick := func(values map[string]float64, def string) (float64, error) {
+		key := opts.Value
+		if key == "" {
+			key = def
+		}
+		value, ok := values[key]
+		if !ok {
+			return 0, fmt.Errorf("can't chart the %s of a %T", key, data)
+		}
+		if key == "count" {
+			opts.Currency = false
+		}
+		return value, nil
+	}
+
+	add := func(label string, values map[string]float64, def string) error {
+		value, err := pick(values, def)
+		datums = append(datums, ChartDatum{label, value})
+		return err
+	}
+
+	summary := func(count, sum int) map[string]float64 {
+		return map[string]float64{"count": float64(count), "sum": float64(sum)}
+	}
+
+	var err error
+
+	switch v := data.(type) {
+	case []ChartDatum:
+		return v, nil
+
+	case []SeriesBucket:
+		for _, b := range v {
+			if err = add(b.Label, summary(b.Count, b.Sum), "sum"); err != nil {
+				break
+			}
+		}
+
+	case []TxnGroupTotal:
+		for _, g := range v {
+			label := g.Label
+			if g.Description != "" {
+				label = g.Description
+			}
+			if err = add(label, summary(g.Count, g.Sum), "sum"); err != nil {
+				break
+			}
+		}
+
+	case map[string]*TxnSummary:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if err = add(key, summary(v[key].Count, v[key].Sum), "sum"); err != nil {
+				break
+			}
+		}
+
+	case []BudgetStatus:
+		for _, b := range v {
+			values := map[string]float64{"spent": float64(b.Spent), "limit": float64(b.Limit), "remaining": float64(b.Remaining)}
+			if err = add(b.Category.Label, values, "spent"); err != nil {
+				break
+			}
+		}
+
+	case []RecurringSeries:
+		for _, s := range v {
+			if err = add(s.Label, map[string]float64{"amount": float64(s.Amount)}, "amount"); err != nil {
+				break
+			}
+		}
+
+	case []Anomaly:
+		for _, a := range v {
+			if err = add(a.Label, map[string]float64{"amount": float64(a.Amount), "usual": float64(a.Usual)}, "amount"); err != nil {
+				break
+			}
+		}
+
+	case *Forecast:
+		if v == nil {
+			return nil, nil
+		}
+		return toChartData(v.Days, opts)
+
+	case []ForecastDay:
+		for _, d := range v {
+			values := map[string]float64{"cumulative": float64(d.Cumulative), "net": float64(d.Net),
+				"inflow": float64(d.Inflow), "outflow": float64(d.Outflow)}
+			if err = add(d.Label, values, "cumulative"); err != nil {
+				break
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("can't chart a %T", data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datums, nil
+}
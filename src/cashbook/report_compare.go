@@ -0,0 +1,189 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// A summary for the report period alongside the same summary for an earlier
+// period
+type SummaryComparison struct {
+	Current  *TxnSummary
+	Previous *TxnSummary
+
+	PreviousStart time.Time
+	PreviousEnd   time.Time
+
+	Change        int     // Current less previous sum
+	CountChange   int     // Current less previous count
+	PercentChange float64 // Change as a percentage of the previous sum, 0 if there was none
+}
+
+// Increased, Decreased and Unchanged make for easy wording in templates
+func (c *SummaryComparison) Increased() bool {
+	return c.Change > 0
+}
+
+func (c *SummaryComparison) Decreased() bool {
+	return c.Change < 0
+}
+
+func (c *SummaryComparison) Unchanged() bool {
+	return c.Change == 0
+}
+
+// Return an api over the period of the same length immediately before this
+// one.  A period of whole months moves back by that many months, so January
+// compares with December and Q2 with Q1.
+func (r *ReportingApi) PreviousPeriod() *ReportingApi {
+
+	start := r.PeriodStart.In(r.location())
+
+	if months := r.wholeMonths(); months > 0 {
+		prevStart := start.AddDate(0, -months, 0)
+		return r.withPeriod(prevStart, start.Add(-time.Second))
+	}
+
+	// Count calendar days, as a period spanning a DST change isn't a whole
+	// number of 24 hour days
+	end := r.PeriodEnd.In(r.location())
+	endDay := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, r.location())
+
+	days := 1
+	for d := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, r.location()); d.Before(endDay); d = d.AddDate(0, 0, 1) {
+		days++
+	}
+
+	return r.withPeriod(start.AddDate(0, 0, -days), start.Add(-time.Second))
+}
+
+// Return an api over the same period a year earlier
+func (r *ReportingApi) SamePeriodLastYear() *ReportingApi {
+
+	start := r.PeriodStart.In(r.location())
+	prevStart := start.AddDate(-1, 0, 0)
+
+	if months := r.wholeMonths(); months > 0 {
+		// Keep to month ends, so February is still all of February
+		return r.withPeriod(prevStart, prevStart.AddDate(0, months, 0).Add(-time.Second))
+	}
+
+	return r.withPeriod(prevStart, r.PeriodEnd.In(r.location()).AddDate(-1, 0, 0))
+}
+
+// Compare the count and sum of txns of txnType (W or D) with an earlier
+// period, against is "previous" (PreviousPeriod) or "lastyear" (SamePeriodLastYear).
+func (r *ReportingApi) CompareTxnType(txnType string, against string) *SummaryComparison {
+	return r.compare(against, func(api *ReportingApi) *TxnSummary {
+		return api.TxnTypeSummary(txnType)
+	})
+}
+
+// Compare the count and sum of txns grouped by txnGroupType with an earlier period
+func (r *ReportingApi) CompareTxnGroupType(txnGroupType string, against string) *SummaryComparison {
+	return r.compare(against, func(api *ReportingApi) *TxnSummary {
+		return api.TxnGroupTypeSummary(txnGroupType)
+	})
+}
+
+// Compare the count and sum of txns with the given classification with an earlier period
+func (r *ReportingApi) CompareClassification(classification string, against string) *SummaryComparison {
+	return r.compare(against, func(api *ReportingApi) *TxnSummary {
+		return api.ClassificationSummary(classification)
+	})
+}
+
+// Compare a txn group's summary (as TxnGroupSummary) with an earlier period
+func (r *ReportingApi) CompareTxnGroup(txnGroupId int64, txnType string, against string) *SummaryComparison {
+	return r.compare(against, func(api *ReportingApi) *TxnSummary {
+		return api.TxnGroupSummary(txnGroupId, txnType)
+	})
+}
+
+func (r *ReportingApi) compare(against string, summarize func(api *ReportingApi) *TxnSummary) *SummaryComparison {
+
+	var earlier *ReportingApi
+
+	switch against {
+	case "previous":
+		earlier = r.PreviousPeriod()
+	case "lastyear":
+		earlier = r.SamePeriodLastYear()
+	default:
+		log.Printf("Error comparing periods: unknown period %q (expected previous or lastyear)", against)
+		return nil
+	}
+
+	current := summarize(r)
+	previous := summarize(earlier)
+
+	if current == nil || previous == nil {
+		return nil
+	}
+
+	comparison := &SummaryComparison{
+		Current:       current,
+		Previous:      previous,
+		PreviousStart: earlier.PeriodStart,
+		PreviousEnd:   earlier.PeriodEnd,
+		Change:        current.Sum - previous.Sum,
+		CountChange:   current.Count - previous.Count,
+	}
+
+	if previous.Sum != 0 {
+		comparison.PercentChange = float64(comparison.Change) * 100 / math.Abs(float64(previous.Sum))
+	}
+
+	return comparison
+}
+
+// Return the number of months in the period if it runs from the start of a
+// month to the end of the last second of a month, otherwise 0.
+func (r *ReportingApi) wholeMonths() int {
+
+	start := r.PeriodStart.In(r.location())
+	end := r.PeriodEnd.In(r.location()).Add(time.Second).Truncate(time.Second) // Periods end at 23:59:59
+
+	whole := func(t time.Time) bool {
+		return t.Day() == 1 && t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+	}
+
+	if !whole(start) || !whole(end) {
+		return 0
+	}
+
+	months := (end.Year()-start.Year())*12 + int(end.Month()) - int(start.Month())
+	if months < 1 {
+		return 0
+	}
+
+	return months
+}
+
+// Return a copy of the api over a different period
+func (r *ReportingApi) withPeriod(start time.Time, end time.Time) *ReportingApi {
+	api := *r
+	api.PeriodStart = start
+	api.PeriodEnd = end
+	return &api
+}
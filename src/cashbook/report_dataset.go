@@ -0,0 +1,222 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// The data behind a report, for feeding other systems rather than rendering
+// through a template.  Sums are in pennies, maps are keyed by txn type (W or
+// D), txn group type or classification.
+type ReportDataset struct {
+	AccountGroupId string `json:",omitempty"`
+	System         bool
+
+	PeriodStart string // yyyy-mm-dd
+	PeriodEnd   string
+	Timezone    string
+
+	TxnTypes        map[string]*TxnSummary
+	TxnGroupTypes   map[string]*TxnSummary // As TxnGroupTypeSummary
+	Classifications map[string]*TxnSummary // As ClassificationSummary
+
+	TopTxnGroups map[string][]TxnGroupTotal // As TopTxnGroups
+
+	Interval string // Of the trends, day, week, month or year
+	Trends   map[string][]SeriesBucket
+}
+
+// The two txn types, in the order datasets list them
+var datasetTxnTypes = []string{"W", "D"}
+
+// Return the txn group types of the period's txns
+func (r *ReportingApi) TxnGroupTypes() []string {
+	values, err := r.distinct("txn_group_type")
+	if err != nil {
+		log.Printf("Error getting TxnGroupTypes: %v", err)
+	}
+	return values
+}
+
+// Return the classifications of the period's txns
+func (r *ReportingApi) Classifications() []string {
+	values, err := r.distinct("classification")
+	if err != nil {
+		log.Printf("Error getting Classifications: %v", err)
+	}
+	return values
+}
+
+func (r *ReportingApi) distinct(column string) ([]string, error) {
+
+	var values []string
+
+	totals := r.totals()
+
+	_, err := r.dbmap.Select(&values, "SELECT DISTINCT "+column+" FROM "+totals.table+" WHERE "+r.txnScope()+
+		" AND "+totals.period+" AND "+column+" <> '' ORDER BY 1", r.queryParams())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// Return the report's dataset: the period, summaries by txn type, txn group
+// type and classification, the top (up to top) txn groups of each txn type
+// and each txn type's trend by interval.  Returns nil if any part fails.
+func (r *ReportingApi) Dataset(top int, interval string) *ReportDataset {
+
+	dataset := &ReportDataset{
+		AccountGroupId:  r.AccountGroupId,
+		System:          r.IsSystem(),
+		PeriodStart:     r.PeriodStart.In(r.location()).Format("2006-01-02"),
+		PeriodEnd:       r.PeriodEnd.In(r.location()).Format("2006-01-02"),
+		Timezone:        r.location().String(),
+		TxnTypes:        map[string]*TxnSummary{},
+		TxnGroupTypes:   map[string]*TxnSummary{},
+		Classifications: map[string]*TxnSummary{},
+		TopTxnGroups:    map[string][]TxnGroupTotal{},
+		Interval:        interval,
+		Trends:          map[string][]SeriesBucket{},
+	}
+
+	summarize := func(into map[string]*TxnSummary, keys []string, summary func(key string) *TxnSummary) bool {
+		for _, key := range keys {
+			if into[key] = summary(key); into[key] == nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	groupTypes, err := r.distinct("txn_group_type")
+	if err != nil {
+		log.Printf("Error getting Dataset: %v", err)
+		return nil
+	}
+
+	classifications, err := r.distinct("classification")
+	if err != nil {
+		log.Printf("Error getting Dataset: %v", err)
+		return nil
+	}
+
+	if !summarize(dataset.TxnTypes, datasetTxnTypes, r.TxnTypeSummary) ||
+		!summarize(dataset.TxnGroupTypes, groupTypes, r.TxnGroupTypeSummary) ||
+		!summarize(dataset.Classifications, classifications, r.ClassificationSummary) {
+		return nil
+	}
+
+	for _, txnType := range datasetTxnTypes {
+		groups := r.TopTxnGroups(txnType, top)
+		if groups == nil {
+			return nil
+		}
+		dataset.TopTxnGroups[txnType] = groups
+
+		trend := r.TxnTypeSeries(interval, txnType)
+		if trend == nil {
+			return nil
+		}
+		dataset.Trends[txnType] = trend
+	}
+
+	return dataset
+}
+
+// Write the dataset as indented JSON
+func (d *ReportDataset) WriteJSON(w io.Writer) error {
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// The CSV columns, one row per summary, top txn group or trend bucket
+var datasetCsvHeader = []string{"account_group_id", "period_start", "period_end", "section", "key", "label", "txn_type", "count", "sum"}
+
+// Write the dataset as CSV rows, the section column says which part of the
+// dataset a row is from (txn_type, txn_group_type, classification,
+// top_txn_group or trend).
+func (d *ReportDataset) WriteCSV(w io.Writer) error {
+
+	out := csv.NewWriter(w)
+	out.Write(datasetCsvHeader)
+
+	row := func(section, key, label, txnType string, count, sum int) {
+		out.Write([]string{d.AccountGroupId, d.PeriodStart, d.PeriodEnd, section, key, label, txnType,
+			strconv.Itoa(count), strconv.Itoa(sum)})
+	}
+
+	summaries := func(section string, m map[string]*TxnSummary) {
+		for _, key := range sortedKeys(m) {
+			row(section, key, key, "", m[key].Count, m[key].Sum)
+		}
+	}
+
+	for _, txnType := range datasetTxnTypes {
+		if s := d.TxnTypes[txnType]; s != nil {
+			row("txn_type", txnType, txnType, txnType, s.Count, s.Sum)
+		}
+	}
+
+	summaries("txn_group_type", d.TxnGroupTypes)
+	summaries("classification", d.Classifications)
+
+	for _, txnType := range datasetTxnTypes {
+		for _, g := range d.TopTxnGroups[txnType] {
+			row("top_txn_group", strconv.FormatInt(g.Id, 10), g.Label, txnType, g.Count, g.Sum)
+		}
+	}
+
+	for _, txnType := range datasetTxnTypes {
+		for _, b := range d.Trends[txnType] {
+			row("trend", b.Start.Format("2006-01-02"), b.Label, txnType, b.Count, b.Sum)
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+func sortedKeys(m map[string]*TxnSummary) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,260 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Days of history the forecast's everyday spending and deposits are averaged over
+const forecastHistoryDays = 90
+
+// The txn group type of paycheques, projected on their own cadence even when
+// recurring:detect hasn't found a series for them
+const payrollGroupType = "Payroll"
+
+// Projected inflows and outflows for one day of a forecast
+type ForecastDay struct {
+	Date  time.Time
+	Label string
+
+	Inflow  int // Deposits, in pennies
+	Outflow int // Withdrawals, in pennies
+	Net     int // Inflow less outflow
+
+	Cumulative int // Net for the forecast so far, including this day
+}
+
+// A forecast of an account group's cash flow
+type Forecast struct {
+	Start time.Time // The first day forecast
+	End   time.Time // The last day forecast
+
+	Days []ForecastDay
+
+	Inflow  int
+	Outflow int
+	Net     int
+
+	// The smallest Cumulative, how far short the member could run, and when
+	LowestCumulative int
+	LowestDate       time.Time
+}
+
+// Return a forecast of daily inflows and outflows for the given number of
+// days (30, 60, 90...) starting the day after the report period ends.
+//
+// Recurring series (see recurring:detect) are projected on their cadence at
+// their next amount.  Paycheques without a series are projected on their
+// average spacing over recent history.  Everything else is projected at its
+// average for the day of the week over the last 90 days.
+func (r *ReportingApi) Forecast(days int) *Forecast {
+
+	if days < 1 {
+		log.Printf("Error getting Forecast: days must be at least 1, got %d", days)
+		return nil
+	}
+
+	loc := r.location()
+	end := r.PeriodEnd.In(loc)
+	start := time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, loc)
+
+	forecast := &Forecast{Start: start, End: start.AddDate(0, 0, days-1)}
+
+	index := map[string]int{}
+	for i := 0; i < days; i++ {
+		day := start.AddDate(0, 0, i)
+		forecast.Days = append(forecast.Days, ForecastDay{Date: day, Label: day.Format("Jan 2")})
+		index[day.Format("2006-01-02")] = i
+	}
+
+	add := func(t time.Time, txnType string, amount int) {
+		i, ok := index[t.In(loc).Format("2006-01-02")]
+		if !ok {
+			return
+		}
+		if txnType == "D" {
+			forecast.Days[i].Inflow += amount
+		} else {
+			forecast.Days[i].Outflow += amount
+		}
+	}
+
+	if !r.forecastRecurring(forecast, add) || !r.forecastPayroll(forecast, add) || !r.forecastEveryday(forecast) {
+		return nil
+	}
+
+	cumulative := 0
+	for i := range forecast.Days {
+		day := &forecast.Days[i]
+		day.Net = day.Inflow - day.Outflow
+		cumulative += day.Net
+		day.Cumulative = cumulative
+
+		forecast.Inflow += day.Inflow
+		forecast.Outflow += day.Outflow
+
+		if i == 0 || cumulative < forecast.LowestCumulative {
+			forecast.LowestCumulative = cumulative
+			forecast.LowestDate = day.Date
+		}
+	}
+
+	forecast.Net = forecast.Inflow - forecast.Outflow
+
+	return forecast
+}
+
+// Project each recurring series over the forecast.  Series that are overdue
+// are assumed to have been missed (or not imported yet) and pick up at their
+// next occurrence in the forecast.
+func (r *ReportingApi) forecastRecurring(forecast *Forecast, add func(t time.Time, txnType string, amount int)) bool {
+
+	series, err := r.recurringSeries("")
+	if err != nil {
+		log.Printf("Error getting Forecast recurring series: %v", err)
+		return false
+	}
+
+	for _, s := range series {
+		cadence := findCadence(s.Cadence)
+		if cadence == nil {
+			continue
+		}
+
+		for t := s.NextExpected; !t.After(forecast.End.AddDate(0, 0, 1)); t = cadence.next(t) {
+			if !t.Before(forecast.Start) {
+				add(t, s.TxnType, int(s.NextAmount))
+			}
+		}
+	}
+
+	return true
+}
+
+// Project paycheques that have no recurring series (irregular pay days, or
+// too new to have been detected) on their average spacing and amount.
+func (r *ReportingApi) forecastPayroll(forecast *Forecast, add func(t time.Time, txnType string, amount int)) bool {
+
+	var paycheques []struct {
+		Count int
+		Sum   int
+		First time.Time
+		Last  time.Time
+	}
+
+	params := r.queryParams()
+	params["payroll"] = payrollGroupType
+	params["hstart"] = forecast.Start.AddDate(0, 0, -forecastHistoryDays)
+	params["hend"] = forecast.Start
+
+	_, err := r.dbmap.Select(&paycheques, "SELECT count(*) as count, sum(amount) as sum, min(occurred_at) as first, max(occurred_at) as last "+
+		"FROM txns t WHERE "+r.txnScope()+" AND t.txn_type = 'D' AND t.txn_group_type = :payroll "+
+		"AND t.occurred_at >= :hstart AND t.occurred_at < :hend "+
+		"AND NOT EXISTS (SELECT 1 FROM recurring_series s WHERE s.txn_group_id = t.txn_group_id AND s.txn_type = t.txn_type) "+
+		"GROUP BY t.txn_group_id", params)
+
+	if err != nil {
+		log.Printf("Error getting Forecast payroll: %v", err)
+		return false
+	}
+
+	for _, p := range paycheques {
+		amount := p.Sum / p.Count
+
+		if p.Count < 2 {
+			// Can't tell how often it comes, spread it over the history instead
+			for i := range forecast.Days {
+				forecast.Days[i].Inflow += amount / forecastHistoryDays
+			}
+			continue
+		}
+
+		gap := p.Last.Sub(p.First) / time.Duration(p.Count-1)
+		if gap < 24*time.Hour {
+			continue // Several on one day isn't a cadence
+		}
+
+		for t := p.Last.Add(gap); !t.After(forecast.End.AddDate(0, 0, 1)); t = t.Add(gap) {
+			add(t, "D", amount)
+		}
+	}
+
+	return true
+}
+
+// Project everything that isn't recurring or payroll at its average for the
+// day of the week over the recent history.
+func (r *ReportingApi) forecastEveryday(forecast *Forecast) bool {
+
+	var totals []struct {
+		Dow     int
+		TxnType string `db:"txn_type"`
+		Sum     int
+	}
+
+	hstart := forecast.Start.AddDate(0, 0, -forecastHistoryDays)
+
+	params := r.queryParams()
+	params["payroll"] = payrollGroupType
+	params["hstart"] = hstart
+	params["hend"] = forecast.Start
+
+	_, err := r.dbmap.Select(&totals, "SELECT CAST(extract(dow FROM t.occurred_at AT TIME ZONE :tz) AS int) as dow, t.txn_type, sum(t.amount) as sum "+
+		"FROM txns t WHERE "+r.txnScope()+" AND t.occurred_at >= :hstart AND t.occurred_at < :hend "+
+		"AND NOT (t.txn_type = 'D' AND t.txn_group_type = :payroll) "+
+		"AND NOT EXISTS (SELECT 1 FROM recurring_series s WHERE s.txn_group_id = t.txn_group_id AND s.txn_type = t.txn_type) "+
+		"GROUP BY 1, 2", params)
+
+	if err != nil {
+		log.Printf("Error getting Forecast history: %v", err)
+		return false
+	}
+
+	// How many of each weekday the history covers
+	var weekdays [7]int
+	for t := hstart; t.Before(forecast.Start); t = t.AddDate(0, 0, 1) {
+		weekdays[t.Weekday()]++
+	}
+
+	for _, total := range totals {
+		if total.Dow < 0 || total.Dow > 6 || weekdays[total.Dow] == 0 {
+			continue
+		}
+
+		average := total.Sum / weekdays[total.Dow]
+
+		for i := range forecast.Days {
+			day := &forecast.Days[i]
+			if int(day.Date.Weekday()) != total.Dow {
+				continue
+			}
+
+			if total.TxnType == "D" {
+				day.Inflow += average
+			} else {
+				day.Outflow += average
+			}
+		}
+	}
+
+	return true
+}
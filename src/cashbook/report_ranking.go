@@ -0,0 +1,89 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+)
+
+// A txn group along with its totals for the report period
+type TxnGroupTotal struct {
+	TxnGroup
+
+	Count int
+	Sum   int
+}
+
+// Return the txn groups with the largest totals of txnType (W or D) for the
+// period, largest first.  Totals are net of the other type (refunds, etc) as
+// in TxnGroupSummary, and a limit of 0 returns every group with a total.
+func (r *ReportingApi) TopTxnGroups(txnType string, limit int) []TxnGroupTotal {
+	return r.rankTxnGroups("", nil, txnType, limit)
+}
+
+// Return the txn groups of txnGroupType (Bills, Retail, etc) with the largest
+// totals for the period, as TopTxnGroups.
+func (r *ReportingApi) TopTxnGroupsByType(txnGroupType string, txnType string, limit int) []TxnGroupTotal {
+	return r.rankTxnGroups("txn_group_type = :filter", txnGroupType, txnType, limit)
+}
+
+// Return the txn groups with the given classification (Dining, Groceries,
+// etc) with the largest totals for the period, as TopTxnGroups.
+func (r *ReportingApi) TopTxnGroupsByClassification(classification string, txnType string, limit int) []TxnGroupTotal {
+	return r.rankTxnGroups("classification = :filter", classification, txnType, limit)
+}
+
+func (r *ReportingApi) rankTxnGroups(filter string, value interface{}, txnType string, limit int) []TxnGroupTotal {
+
+	params := r.queryParams()
+	params["type"] = txnType
+	params["filter"] = value
+
+	totals := r.totals()
+
+	where := r.txnScope() + " AND " + totals.period
+	if filter != "" {
+		where += " AND " + filter
+	}
+
+	query := "SELECT g.*, t.count, t.sum FROM txn_groups g JOIN (" +
+		"SELECT " + r.txnGroupColumn() + " as group_id, " +
+		"sum(CASE WHEN txn_type = :type THEN " + totals.count + " ELSE -" + totals.count + " END) as count, " +
+		"sum(CASE WHEN txn_type = :type THEN amount ELSE -amount END) as sum " +
+		"FROM " + totals.table + " WHERE " + where + " GROUP BY 1) t ON t.group_id = g.id " +
+		"WHERE t.sum > 0 ORDER BY t.sum DESC, g.label"
+
+	if limit > 0 {
+		query += " LIMIT :limit"
+		params["limit"] = limit
+	}
+
+	// Empty rather than nil when there are none, nil is for errors
+	groups := []TxnGroupTotal{}
+
+	_, err := r.dbmap.Select(&groups, query, params)
+
+	if err != nil {
+		log.Printf("Error getting top TxnGroups: %v", err)
+		return nil
+	}
+
+	return groups
+}
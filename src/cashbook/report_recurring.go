@@ -0,0 +1,104 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+)
+
+// Return the recurring series (as found by recurring:detect) of txnType (W
+// or D, empty for both), soonest expected first.
+func (r *ReportingApi) RecurringSeries(txnType string) []RecurringSeries {
+
+	series, err := r.recurringSeries(txnType)
+	if err != nil {
+		log.Printf("Error getting RecurringSeries: %v", err)
+		return nil
+	}
+
+	return series
+}
+
+func (r *ReportingApi) recurringSeries(txnType string) ([]RecurringSeries, error) {
+
+	var series []RecurringSeries
+
+	params := r.queryParams()
+	params["type"] = txnType
+
+	_, err := r.dbmap.Select(&series, "SELECT * FROM recurring_series WHERE "+r.txnScope()+
+		" AND (:type = '' OR txn_type = :type) ORDER BY next_expected, label", params)
+
+	r.localizeSeries(series)
+
+	return series, err
+}
+
+// Convert the series' times to the report's timezone, so they show the
+// member's local dates
+func (r *ReportingApi) localizeSeries(series []RecurringSeries) {
+	for i := range series {
+		series[i].FirstSeen = series[i].FirstSeen.In(r.location())
+		series[i].LastSeen = series[i].LastSeen.In(r.location())
+		series[i].NextExpected = series[i].NextExpected.In(r.location())
+	}
+}
+
+// Return the recurring series of txnType next expected during the report
+// period, a member's upcoming bills and subscriptions.
+func (r *ReportingApi) RecurringDue(txnType string) []RecurringSeries {
+
+	var series []RecurringSeries
+
+	params := r.queryParams()
+	params["type"] = txnType
+
+	_, err := r.dbmap.Select(&series, "SELECT * FROM recurring_series WHERE "+r.txnScope()+
+		" AND (:type = '' OR txn_type = :type) AND next_expected BETWEEN :rstart AND :rend ORDER BY next_expected, label", params)
+
+	if err != nil {
+		log.Printf("Error getting RecurringDue: %v", err)
+		return nil
+	}
+
+	r.localizeSeries(series)
+
+	return series
+}
+
+// Return the count and typical total of the recurring series of txnType, what
+// a member's subscriptions and regular bills add up to each time around.
+func (r *ReportingApi) RecurringSummary(txnType string) *TxnSummary {
+
+	summary := TxnSummary{}
+
+	params := r.queryParams()
+	params["type"] = txnType
+
+	err := r.dbmap.SelectOne(&summary, "SELECT count(*) as count, coalesce(sum(amount), 0) as sum FROM recurring_series WHERE "+
+		r.txnScope()+" AND (:type = '' OR txn_type = :type)", params)
+
+	if err != nil {
+		log.Printf("Error getting RecurringSummary: %v", err)
+		return nil
+	}
+
+	return &summary
+}
@@ -0,0 +1,197 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// One bucket of a time series, covering the txns from Start up to the start
+// of the next bucket.
+type SeriesBucket struct {
+	Start time.Time
+	Label string // Short display label, "Jan 2014", "Jan 6", etc
+	Count int
+	Sum   int
+}
+
+// Return per interval (day, week, month or year) counts and sums for the
+// txns of txnType (W or D) in the period.
+func (r *ReportingApi) TxnTypeSeries(interval string, txnType string) []SeriesBucket {
+	return r.series(interval, "txn_type = :value", txnType, seriesColumns)
+}
+
+// Return per interval counts and sums for the txns grouped by txnGroupType (Bills, Retail, etc)
+func (r *ReportingApi) TxnGroupTypeSeries(interval string, txnGroupType string) []SeriesBucket {
+	return r.series(interval, "txn_group_type = :value", txnGroupType, seriesColumns)
+}
+
+// Return per interval counts and sums for the txns with the given classification (Dining, Groceries, etc)
+func (r *ReportingApi) ClassificationSeries(interval string, classification string) []SeriesBucket {
+	return r.series(interval, "classification = :value", classification, seriesColumns)
+}
+
+// Return per interval counts and sums for a txn group's txns of txnType, net
+// of its txns of the other type (refunds, etc) just as TxnGroupSummary.
+func (r *ReportingApi) TxnGroupSeries(interval string, txnGroupId int64, txnType string) []SeriesBucket {
+	return r.series(interval, r.txnGroupColumn()+" = :value", txnGroupId, netSeriesColumns, "type", txnType)
+}
+
+// The count and sum columns of a series, read from t
+func seriesColumns(t txnTotals) string {
+	return "coalesce(sum(" + t.count + "), 0) as count, coalesce(sum(amount), 0) as sum"
+}
+
+// The same, net of the txns that aren't of the :type txn type
+func netSeriesColumns(t txnTotals) string {
+	return "coalesce(sum(CASE WHEN txn_type = :type THEN " + t.count + " ELSE -" + t.count + " END), 0) as count, " +
+		"coalesce(sum(CASE WHEN txn_type = :type THEN amount ELSE -amount END), 0) as sum"
+}
+
+// Query the period's txns matching where, bucketed by interval in the
+// report's timezone.  Every bucket in the period is returned, empty ones
+// with a zero count and sum.  Month and year buckets are read from the
+// monthly summaries when the period allows.  Extra query parameters are
+// given as name, value pairs.
+func (r *ReportingApi) series(interval string, where string, value interface{}, columns func(t txnTotals) string, extra ...interface{}) []SeriesBucket {
+
+	buckets := r.seriesBuckets(interval)
+	if buckets == nil {
+		log.Printf("Error getting series: unknown interval %q (expected day, week, month or year)", interval)
+		return nil
+	}
+
+	params := r.queryParams()
+	params["interval"] = interval
+	params["value"] = value
+	for i := 0; i+1 < len(extra); i += 2 {
+		params[extra[i].(string)] = extra[i+1]
+	}
+
+	var rows []struct {
+		Bucket string
+		Count  int
+		Sum    int
+	}
+
+	// Summary months are already in the report's timezone
+	t, bucket := txnTotalsFromTxns, "occurred_at AT TIME ZONE :tz"
+	if interval == "month" || interval == "year" {
+		if t = r.totals(); t.table == txnTotalsFromSummaries.table {
+			bucket = "CAST(month AS timestamp)"
+		}
+	}
+
+	_, err := r.dbmap.Select(&rows, "SELECT to_char(date_trunc(:interval, "+bucket+"), 'YYYY-MM-DD') as bucket, "+columns(t)+
+		" FROM "+t.table+" WHERE "+r.txnScope()+" AND "+where+" AND "+t.period+" GROUP BY 1",
+		params)
+
+	if err != nil {
+		log.Printf("Error getting series: %v", err)
+		return nil
+	}
+
+	index := map[string]int{}
+	for i, bucket := range buckets {
+		index[bucket.Start.Format("2006-01-02")] = i
+	}
+
+	for _, row := range rows {
+		if i, ok := index[row.Bucket]; ok {
+			buckets[i].Count = row.Count
+			buckets[i].Sum = row.Sum
+		}
+	}
+
+	return buckets
+}
+
+// Return the empty buckets covering the period, or nil for an unknown interval
+func (r *ReportingApi) seriesBuckets(interval string) []SeriesBucket {
+
+	var buckets []SeriesBucket
+
+	start := truncateTime(r.PeriodStart.In(r.location()), interval)
+	if start.IsZero() {
+		return nil
+	}
+
+	for t := start; !t.After(r.PeriodEnd); t = nextInterval(t, interval) {
+		buckets = append(buckets, SeriesBucket{Start: t, Label: intervalLabel(t, interval)})
+	}
+
+	return buckets
+}
+
+// True for the intervals series support
+func validInterval(interval string) bool {
+	return !truncateTime(time.Now(), interval).IsZero()
+}
+
+// Return the start of the interval (day, week, month or year) containing t,
+// weeks start on Monday (as postgres' date_trunc has them).  Returns the
+// zero time for an unknown interval.
+func truncateTime(t time.Time, interval string) time.Time {
+
+	year, month, day := t.Date()
+
+	switch interval {
+	case "day":
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case "week":
+		offset := (int(t.Weekday()) + 6) % 7 // Days since Monday
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case "year":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+
+	return time.Time{}
+}
+
+// Return the start of the interval following the one starting at t
+func nextInterval(t time.Time, interval string) time.Time {
+	switch interval {
+	case "day":
+		return t.AddDate(0, 0, 1)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	case "year":
+		return t.AddDate(1, 0, 0)
+	}
+
+	panic(fmt.Sprintf("unknown interval %q", interval))
+}
+
+func intervalLabel(t time.Time, interval string) string {
+	switch interval {
+	case "month":
+		return t.Format("Jan 2006")
+	case "year":
+		return t.Format("2006")
+	}
+
+	return t.Format("Jan 2")
+}
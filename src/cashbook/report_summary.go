@@ -0,0 +1,96 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// Where a query reads the period's txn counts and totals from.  The monthly
+// summaries have the same txn type, txn group and classification columns as
+// txns, with a count of txns and the total of their amounts per row.
+type txnTotals struct {
+	table  string // txns or txn_month_summaries
+	count  string // The number of txns a row counts for
+	period string // Where clause restricting rows to the report period
+}
+
+var txnTotalsFromTxns = txnTotals{
+	table:  "txns",
+	count:  "1",
+	period: "occurred_at BETWEEN :rstart AND :rend",
+}
+
+var txnTotalsFromSummaries = txnTotals{
+	table:  "txn_month_summaries",
+	count:  "txn_count",
+	period: "timezone = :tz AND month >= CAST(:mstart AS date) AND month < CAST(:mend AS date)",
+}
+
+// Whether summaries have been built in each timezone, looked up once
+var summaryTimezones = struct {
+	sync.Mutex
+	built map[string]bool
+}{built: map[string]bool{}}
+
+// Return where to read the period's totals from: the monthly summaries when
+// the period is whole months and summaries have been built in the report's
+// timezone, the txns otherwise
+func (r *ReportingApi) totals() txnTotals {
+
+	if _, _, ok := r.summaryMonths(); !ok {
+		return txnTotalsFromTxns
+	}
+
+	summaryTimezones.Lock()
+	defer summaryTimezones.Unlock()
+
+	timezone := r.location().String()
+
+	built, ok := summaryTimezones.built[timezone]
+	if !ok {
+		var err error
+		if built, err = summaries_built(r.location(), r.dbmap); err != nil {
+			log.Printf("Error checking for summaries, using txns: %v", err)
+			return txnTotalsFromTxns
+		}
+		summaryTimezones.built[timezone] = built
+	}
+
+	if built {
+		return txnTotalsFromSummaries
+	}
+	return txnTotalsFromTxns
+}
+
+// Return the first of the period's first month and of the month after its
+// last (yyyy-mm-dd), and whether the period is whole months (see wholeMonths)
+func (r *ReportingApi) summaryMonths() (string, string, bool) {
+
+	months := r.wholeMonths()
+	if months == 0 {
+		return "", "", false
+	}
+
+	start := r.PeriodStart.In(r.location())
+
+	return start.Format("2006-01-02"), start.AddDate(0, months, 0).Format("2006-01-02"), true
+}
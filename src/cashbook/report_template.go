@@ -0,0 +1,219 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// A parsed report template, html/template or text/template
+type reportTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// Outputs that are rendered with html/template, escaping data for HTML.
+// Everything else (txt, csv, md, tex...) is rendered as is.
+var htmlExtensions = map[string]bool{"html": true, "htm": true, "xhtml": true, "svg": true, "xml": true}
+
+// Return the engine, html or text, to render a template with: the one asked
+// for or else the one for the template's extension
+func templateEngine(engine string, ext string) (string, error) {
+
+	switch engine {
+	case "html", "text":
+		return engine, nil
+	case "":
+		if htmlExtensions[strings.ToLower(ext)] {
+			return "html", nil
+		}
+		return "text", nil
+	}
+
+	return "", fmt.Errorf("unknown template engine '%s', expected html or text", engine)
+}
+
+// The subdirectories of a template directory shared by every report
+// template: layouts wrapping pages and partials included by them
+var sharedTemplateDirs = []string{"layouts", "partials"}
+
+// A template file's name in the set and its text
+type templateFile struct {
+	name string
+	text string
+}
+
+// Parse a report template file with the given engine and functions.  With a
+// template directory, the files in its layouts and partials subdirectories
+// are parsed first, named by their path in it (layouts/base.html), so the
+// page can use them and its define blocks override their block defaults.
+// Returns the template named render (the page if render is empty).
+func parseReportTemplate(filename string, dir string, render string, engine string, funcs map[string]interface{}) (reportTemplate, error) {
+
+	files, page, err := readReportTemplates(filename, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if render == "" {
+		render = page.name
+	}
+
+	if engine == "html" {
+		t := htmltemplate.New(page.name).Funcs(funcs)
+		for _, f := range files {
+			if _, err := t.New(f.name).Parse(f.text); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := t.Parse(page.text); err != nil {
+			return nil, err
+		}
+		if t = t.Lookup(render); t == nil {
+			return nil, fmt.Errorf("no template named '%s'", render)
+		}
+		return t, nil
+	}
+
+	t := texttemplate.New(page.name).Funcs(funcs)
+	for _, f := range files {
+		if _, err := t.New(f.name).Parse(f.text); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := t.Parse(page.text); err != nil {
+		return nil, err
+	}
+	if t = t.Lookup(render); t == nil {
+		return nil, fmt.Errorf("no template named '%s'", render)
+	}
+	return t, nil
+}
+
+// Read a report template and the shared layouts and partials of the template
+// directory (if any)
+func readReportTemplates(filename string, dir string) ([]templateFile, *templateFile, error) {
+
+	var files []templateFile
+
+	if dir != "" {
+		for _, sub := range sharedTemplateDirs {
+			shared, err := readTemplateDir(dir, sub)
+			if err != nil {
+				return nil, nil, err
+			}
+			files = append(files, shared...)
+		}
+	}
+
+	page, err := readTemplateFile(dir, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return files, page, nil
+}
+
+// Read the files under a subdirectory of the template directory, in name
+// order, skipping hidden ones.  A missing subdirectory has no files.
+func readTemplateDir(dir string, sub string) ([]templateFile, error) {
+
+	var files []templateFile
+
+	root := filepath.Join(dir, sub)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := readTemplateFile(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, *f)
+		return nil
+	})
+
+	return files, err
+}
+
+// Read a template file, named by its path in the template directory if it's
+// in it and its file name otherwise.  A filename that doesn't exist is looked
+// for in the template directory.
+func readTemplateFile(dir string, filename string) (*templateFile, error) {
+
+	if _, err := os.Stat(filename); os.IsNotExist(err) && dir != "" && !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(filename)
+	if dir != "" {
+		if rel, err := filepath.Rel(dir, filename); err == nil && !strings.HasPrefix(rel, "..") {
+			name = filepath.ToSlash(rel)
+		}
+	}
+
+	return &templateFile{name, string(text)}, nil
+}
+
+// Return a version for a report template: the start of a checksum of it
+// and the layouts and partials it's parsed with
+func reportTemplateVersion(filename string, dir string) (string, error) {
+
+	files, page, err := readReportTemplates(filename, dir)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, f := range append(files, *page) {
+		fmt.Fprintf(hash, "%s\x00%d\x00%s", f.name, len(f.text), f.text)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:12], nil
+}
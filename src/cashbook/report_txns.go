@@ -0,0 +1,135 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// A txn of the report period, for itemizing, with the label of its txn
+// group (its description if the member set one) and its category
+type TxnDetail struct {
+	Id             int64
+	OccurredAt     time.Time `db:"occurred_at"` // In the report's timezone
+	TxnType        string    `db:"txn_type"`
+	Amount         int64
+	Description    string
+	TxnGroupId     int64  `db:"txn_group_id"` // The system txn group in system reports
+	TxnGroupType   string `db:"txn_group_type"`
+	Classification string
+	GroupLabel     string `db:"group_label"`
+	CategoryId     int64  `db:"category_id"`
+	Category       string
+}
+
+// The orders txns can be listed in
+var txnOrders = map[string]string{
+	"":         "t.occurred_at, t.id",
+	"oldest":   "t.occurred_at, t.id",
+	"newest":   "t.occurred_at DESC, t.id DESC",
+	"largest":  "t.amount DESC, t.occurred_at, t.id",
+	"smallest": "t.amount, t.occurred_at, t.id",
+}
+
+// Return the period's txns in order (oldest, newest, largest or smallest
+// first), at most limit of them unless it's 0.
+func (r *ReportingApi) Txns(order string, limit int) []TxnDetail {
+	return r.txnDetails("Txns", "", nil, order, limit)
+}
+
+// Return the period's txns in a txn group, as Txns
+func (r *ReportingApi) TxnGroupTxns(txnGroupId int64, order string, limit int) []TxnDetail {
+	return r.txnDetails("TxnGroupTxns", r.txnGroupColumn()+" = :filter", txnGroupId, order, limit)
+}
+
+// Return the period's txns of a txn group type (Bills, Retail, etc), as Txns
+func (r *ReportingApi) TxnGroupTypeTxns(txnGroupType string, order string, limit int) []TxnDetail {
+	return r.txnDetails("TxnGroupTypeTxns", "txn_group_type = :filter", txnGroupType, order, limit)
+}
+
+// Return the period's txns with a classification (Dining, Groceries, etc),
+// as Txns
+func (r *ReportingApi) ClassificationTxns(classification string, order string, limit int) []TxnDetail {
+	return r.txnDetails("ClassificationTxns", "classification = :filter", classification, order, limit)
+}
+
+// Return the period's txns in a spending category, as Txns
+func (r *ReportingApi) CategoryTxns(categoryId int64, order string, limit int) []TxnDetail {
+	return r.txnDetails("CategoryTxns", "category_id = :filter", categoryId, order, limit)
+}
+
+func (r *ReportingApi) txnDetails(method string, filter string, value interface{}, order string, limit int) []TxnDetail {
+
+	txns, err := r.queryTxnDetails(filter, value, order, limit)
+	if err != nil {
+		log.Printf("Error getting %s: %v", method, err)
+		return nil
+	}
+
+	return txns
+}
+
+func (r *ReportingApi) queryTxnDetails(filter string, value interface{}, order string, limit int) ([]TxnDetail, error) {
+
+	orderBy, ok := txnOrders[order]
+	if !ok {
+		return nil, fmt.Errorf("unknown order '%s', expected oldest, newest, largest or smallest", order)
+	}
+
+	params := r.queryParams()
+	params["filter"] = value
+
+	where := r.txnScope() + " AND occurred_at BETWEEN :rstart AND :rend"
+	if filter != "" {
+		where += " AND " + filter
+	}
+
+	query := "SELECT t.id, t.occurred_at, t.txn_type, t.amount, coalesce(t.description, '') as description, " +
+		"coalesce(t." + r.txnGroupColumn() + ", 0) as txn_group_id, coalesce(t.txn_group_type, '') as txn_group_type, " +
+		"coalesce(t.classification, '') as classification, coalesce(nullif(g.description, ''), g.label, '') as group_label, " +
+		"coalesce(t.category_id, 0) as category_id, coalesce(c.label, '') as category " +
+		"FROM (SELECT * FROM txns WHERE " + where + ") t " +
+		"LEFT JOIN txn_groups g ON g.id = t." + r.txnGroupColumn() + " LEFT JOIN categories c ON c.id = t.category_id " +
+		"ORDER BY " + orderBy
+
+	if limit > 0 {
+		query += " LIMIT :limit"
+		params["limit"] = limit
+	}
+
+	var txns []TxnDetail
+
+	if _, err := r.dbmap.Select(&txns, query, params); err != nil {
+		return nil, err
+	}
+
+	for i := range txns {
+		txns[i].OccurredAt = txns[i].OccurredAt.In(r.location())
+	}
+
+	return txns, nil
+}
+
+// True for withdrawals
+func (t TxnDetail) IsWithdrawal() bool {
+	return t.TxnType == "W"
+}
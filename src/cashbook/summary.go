@@ -0,0 +1,202 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// The columns of txn_month_summaries a summary row is kept for
+type summaryKey struct {
+	AccountGroupId   string
+	Month            string // yyyy-mm-01
+	Timezone         string
+	TxnType          string
+	SystemTxnGroupId int64
+	TxnGroupId       int64
+	TxnGroupType     string
+	Classification   string
+}
+
+type summaryTotal struct {
+	Count  int
+	Amount int64
+}
+
+// Additions to the monthly summaries from the txns of an import batch, made
+// along with the batch.  Summaries are kept for each timezone they've been
+// built in.
+type summaryBatch struct {
+	locations []*time.Location
+	totals    map[summaryKey]*summaryTotal
+}
+
+// Return a batch for the timezones summaries have been built in, one that
+// adds nothing when they haven't been
+func load_summary_batch(dbm gorp.SqlExecutor) (*summaryBatch, error) {
+
+	batch := &summaryBatch{totals: map[summaryKey]*summaryTotal{}}
+
+	var timezones []string
+	if _, err := dbm.Select(&timezones, "SELECT timezone FROM txn_summary_builds ORDER BY timezone"); err != nil {
+		return nil, err
+	}
+
+	for _, timezone := range timezones {
+		loc, err := loadLocation(timezone)
+		if err != nil {
+			return nil, err
+		}
+		batch.locations = append(batch.locations, loc)
+	}
+
+	return batch, nil
+}
+
+// Count an imported txn
+func (b *summaryBatch) add(txn *Txn) {
+
+	for _, loc := range b.locations {
+		at := txn.OccurredAt.In(loc)
+
+		key := summaryKey{
+			AccountGroupId:   txn.AccountGroupId,
+			Month:            time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, loc).Format("2006-01-02"),
+			Timezone:         loc.String(),
+			TxnType:          txn.TxnType,
+			SystemTxnGroupId: txn.SystemTxnGroupId,
+			TxnGroupId:       txn.TxnGroupId,
+			TxnGroupType:     txn.TxnGroupType,
+			Classification:   txn.Classification,
+		}
+
+		total := b.totals[key]
+		if total == nil {
+			total = &summaryTotal{}
+			b.totals[key] = total
+		}
+
+		total.Count++
+		total.Amount += txn.Amount
+	}
+}
+
+// Add the batch's totals to the summaries and start again
+func (b *summaryBatch) flush(tx gorp.SqlExecutor) error {
+
+	for key, total := range b.totals {
+		_, err := tx.Exec("INSERT INTO txn_month_summaries (account_group_id, month, timezone, txn_type, system_txn_group_id, "+
+			"txn_group_id, txn_group_type, classification, txn_count, amount) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) "+
+			"ON CONFLICT (account_group_id, month, timezone, txn_type, system_txn_group_id, txn_group_id, txn_group_type, classification) "+
+			"DO UPDATE SET txn_count = txn_month_summaries.txn_count + EXCLUDED.txn_count, amount = txn_month_summaries.amount + EXCLUDED.amount",
+			key.AccountGroupId, key.Month, key.Timezone, key.TxnType, key.SystemTxnGroupId, key.TxnGroupId,
+			key.TxnGroupType, key.Classification, total.Count, total.Amount)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	b.totals = map[summaryKey]*summaryTotal{}
+
+	return nil
+}
+
+// Rebuild the monthly summaries in a timezone from the txns, of one account
+// group or (if accountGroupId is empty) all of them, returning how many rows
+// were written.  Once built, imports keep a timezone's summaries up to date.
+func rebuild_summaries(loc *time.Location, accountGroupId string, dbm *gorp.DbMap) (int64, error) {
+
+	tx, err := dbm.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	scope := "TRUE"
+	args := []interface{}{loc.String()}
+	if accountGroupId != "" {
+		scope = "account_group_id = $2"
+		args = append(args, accountGroupId)
+	}
+
+	if _, err := tx.Exec("DELETE FROM txn_month_summaries WHERE timezone = $1 AND "+scope, args...); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	result, err := tx.Exec("INSERT INTO txn_month_summaries (account_group_id, month, timezone, txn_type, system_txn_group_id, "+
+		"txn_group_id, txn_group_type, classification, txn_count, amount) "+
+		"SELECT account_group_id, CAST(date_trunc('month', occurred_at AT TIME ZONE CAST($1 AS text)) AS date), CAST($1 AS text), txn_type, "+
+		"coalesce(system_txn_group_id, 0), coalesce(txn_group_id, 0), coalesce(txn_group_type, ''), coalesce(classification, ''), "+
+		"count(*), sum(amount) FROM txns WHERE account_group_id IS NOT NULL AND "+scope+" GROUP BY 1, 2, 3, 4, 5, 6, 7, 8", args...)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.Exec("INSERT INTO txn_summary_builds (timezone, rebuilt) VALUES ($1, $2) "+
+		"ON CONFLICT (timezone) DO UPDATE SET rebuilt = EXCLUDED.rebuilt", loc.String(), time.Now())
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return rows, tx.Commit()
+}
+
+// Drop the monthly summaries in a timezone, so reports go back to the txns
+// and imports stop maintaining them
+func clear_summaries(loc *time.Location, dbm *gorp.DbMap) error {
+
+	tx, err := dbm.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"txn_month_summaries", "txn_summary_builds"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE timezone = $1", loc.String()); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// True if summaries have been built in the timezone
+func summaries_built(loc *time.Location, dbm gorp.SqlExecutor) (bool, error) {
+
+	count, err := dbm.SelectInt("SELECT count(*) FROM txn_summary_builds WHERE timezone = $1", loc.String())
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
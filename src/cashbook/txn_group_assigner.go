@@ -27,12 +27,13 @@ import (
 	"time"
 )
 
-// Process a single incoming transaction
-func assign_txn_to_txn_group(txn *Txn, matchers MatchSet, dbm *gorp.DbMap) (*Txn) {
+// Process a single incoming transaction, returning any error saving the txn
+// (so a caller inside a transaction knows to roll back).
+func assign_txn_to_txn_group(txn *Txn, matchers MatchSet, dbm gorp.SqlExecutor) (*Txn, error) {
 
 	if !txn.Valid() {
 		log.Printf("Invalid txn: %v", txn)
-		return txn
+		return txn, nil
 	}
 
 	matcher := matchers.FindMatcher(txn.Description)
@@ -55,24 +56,26 @@ func assign_txn_to_txn_group(txn *Txn, matchers MatchSet, dbm *gorp.DbMap) (*Txn
 		txn.CategoryId = txn_group.CategoryId
 	}
 
+	var err error
+
 	if txn.Id != 0 { // TODO Find a better way to do this.
-		_, err := dbm.Update(txn)
+		_, err = dbm.Update(txn)
 		if err != nil {
 			log.Printf("Error updating txn: %v", err)
 		}
 	} else {
 		txn.Created = time.Now().UnixNano()
-		err := dbm.Insert(txn)
+		err = dbm.Insert(txn)
 
 		if err != nil {
 			log.Printf("Error inserting txn: %v", err)
 		}
 	}
 
-	return txn
+	return txn, err
 }
 
-func find_or_create_txn_group(agid string, system_group *TxnGroup, dbm *gorp.DbMap) (txn *TxnGroup) {
+func find_or_create_txn_group(agid string, system_group *TxnGroup, dbm gorp.SqlExecutor) (txn *TxnGroup) {
 
 	// Pre-initialize a new record
 	group := TxnGroup{
@@ -109,7 +112,7 @@ func find_or_create_txn_group(agid string, system_group *TxnGroup, dbm *gorp.DbM
 }
 
 
-func find_or_create_system_txn_group(label string, group_type string, dbm *gorp.DbMap) (txnGroup *TxnGroup) {
+func find_or_create_system_txn_group(label string, group_type string, dbm gorp.SqlExecutor) (txnGroup *TxnGroup) {
 	// Pre-initialize a new record
 	group := TxnGroup{
 		GroupType: group_type,
@@ -0,0 +1,297 @@
+/*
+ * This file is part of Cashbook, a tool to analyze and report on sets of financial transactions.
+ *
+ * Copyright (C) 2014  Sourdough Labs Research and Development Corp.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// A minimal XLSX writer: sheets of text, numbers, amounts and dates with a
+// bold header row, which is all exports need.  Text is written inline
+// rather than in a shared strings table, which Excel reads just as well.
+type xlsxWorkbook struct {
+	sheets []*xlsxSheet
+}
+
+type xlsxSheet struct {
+	Name string
+
+	header bool // The first row is headings, kept in view when scrolling
+	rows   [][]xlsxCell
+}
+
+type xlsxCell struct {
+	value  string
+	number bool
+	style  int
+}
+
+// Cell styles, indexes into the cellXfs of xlsxStyles
+const (
+	xlsxPlain = iota
+	xlsxBold
+	xlsxAmount
+	xlsxDateTime
+	xlsxTotal
+	xlsxDate
+)
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="3"><numFmt numFmtId="164" formatCode="#,##0.00"/><numFmt numFmtId="165" formatCode="yyyy-mm-dd hh:mm"/>` +
+	`<numFmt numFmtId="166" formatCode="yyyy-mm-dd"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="6">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="164" fontId="1" fillId="0" borderId="0" xfId="0" applyNumberFormat="1" applyFont="1"/>` +
+	`<xf numFmtId="166" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs><cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles></styleSheet>`
+
+// Cells of the types exports use
+func xlsxText(s string) xlsxCell {
+	return xlsxCell{value: s}
+}
+
+func xlsxHeading(s string) xlsxCell {
+	return xlsxCell{value: s, style: xlsxBold}
+}
+
+func xlsxNumber(n int) xlsxCell {
+	return xlsxCell{value: strconv.Itoa(n), number: true}
+}
+
+// An amount in pennies, shown in dollars
+func xlsxMoney(pennies int64) xlsxCell {
+	return xlsxCell{value: strconv.FormatFloat(float64(pennies)/100, 'f', 2, 64), number: true, style: xlsxAmount}
+}
+
+func xlsxMoneyTotal(pennies int64) xlsxCell {
+	cell := xlsxMoney(pennies)
+	cell.style = xlsxTotal
+	return cell
+}
+
+// A date and time as it was on the clock in t's location
+func xlsxTime(t time.Time) xlsxCell {
+	return xlsxCell{value: strconv.FormatFloat(excelSerial(t), 'f', -1, 64), number: true, style: xlsxDateTime}
+}
+
+func xlsxDay(t time.Time) xlsxCell {
+	return xlsxCell{value: strconv.FormatFloat(float64(int(excelSerial(t))), 'f', -1, 64), number: true, style: xlsxDate}
+}
+
+// Excel stores dates as days since the end of 1899, times as fractions of
+// a day
+func excelSerial(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	days := wall.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24
+	return float64(int64(days*86400+0.5)) / 86400
+}
+
+// Add a sheet, with a row of headings if any are given.  Names are cut down
+// to what Excel allows.
+func (w *xlsxWorkbook) addSheet(name string, headings ...string) *xlsxSheet {
+
+	name = strings.Map(func(c rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, c) {
+			return '-'
+		}
+		return c
+	}, name)
+
+	for utf8.RuneCountInString(name) > 31 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	sheet := &xlsxSheet{Name: name}
+
+	if len(headings) > 0 {
+		sheet.header = true
+		row := make([]xlsxCell, len(headings))
+		for i, heading := range headings {
+			row[i] = xlsxHeading(heading)
+		}
+		sheet.rows = append(sheet.rows, row)
+	}
+
+	w.sheets = append(w.sheets, sheet)
+
+	return sheet
+}
+
+func (s *xlsxSheet) addRow(cells ...xlsxCell) {
+	s.rows = append(s.rows, cells)
+}
+
+// Return the column letters of a zero based column index: A, B ... Z, AA...
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// Write the sheet's XML, columns sized to fit their content
+func (s *xlsxSheet) write(w io.Writer) error {
+
+	var b bytes.Buffer
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+
+	if s.header {
+		b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	}
+
+	var widths []int
+	for _, row := range s.rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 8)
+			}
+			width := utf8.RuneCountInString(cell.value) + 2
+			if cell.number {
+				width = 14
+			}
+			if width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+
+	if len(widths) > 0 {
+		b.WriteString("<cols>")
+		for i, width := range widths {
+			if width > 60 {
+				width = 60
+			}
+			fmt.Fprintf(&b, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, width)
+		}
+		b.WriteString("</cols>")
+	}
+
+	b.WriteString("<sheetData>")
+
+	for r, row := range s.rows {
+		fmt.Fprintf(&b, `<row r="%d">`, r+1)
+
+		for c, cell := range row {
+			ref := xlsxColumn(c) + strconv.Itoa(r+1)
+
+			if cell.number {
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, cell.style, cell.value)
+				continue
+			}
+
+			fmt.Fprintf(&b, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, ref, cell.style)
+			xml.EscapeText(&b, []byte(cell.value))
+			b.WriteString("</t></is></c>")
+		}
+
+		b.WriteString("</row>")
+	}
+
+	b.WriteString("</sheetData></worksheet>")
+
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// Write the workbook as an XLSX (zipped SpreadsheetML) file
+func (w *xlsxWorkbook) Write(out io.Writer) error {
+
+	const header = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+	const relationships = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+
+	var types, sheets, rels bytes.Buffer
+
+	types.WriteString(header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+
+	rels.WriteString(header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	for i, sheet := range w.sheets {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, relationships, i+1)
+
+		sheets.WriteString(`<sheet name="`)
+		xml.EscapeText(&sheets, []byte(sheet.Name))
+		fmt.Fprintf(&sheets, `" sheetId="%d" r:id="rId%d"/>`, i+1, i+1)
+	}
+
+	types.WriteString("</Types>")
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/styles" Target="styles.xml"/></Relationships>`, len(w.sheets)+1, relationships)
+
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="` + relationships + `/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="` + relationships + `">` +
+			`<sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+
+	zw := zip.NewWriter(out)
+
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	for i, sheet := range w.sheets {
+		f, err := zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+		if err != nil {
+			return err
+		}
+		if err := sheet.write(f); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}